@@ -0,0 +1,266 @@
+// Package changelog decodes the changesets stored in the changelog.
+package changelog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashka/hgo/revlog"
+)
+
+// A changeset revision is the text:
+//
+// 	<manifest hex node>\n
+// 	<user>\n
+// 	<unix time> <timezone offset>[ <extra>]\n
+// 	<file>\n...
+// 	\n
+// 	<description>
+//
+// The timezone offset is in seconds west of UTC. Extra is a "\0"-separated list
+// of escaped "key:value" items. The list of files may be empty.
+// The null revision has an empty text.
+//
+// Source: mercurial/changelog.py
+
+// ErrCorrupt is returned for a changeset text that cannot be parsed.
+var ErrCorrupt = errors.New("corrupt changeset")
+
+// DefaultBranch is the branch of changesets without a "branch" extra.
+const DefaultBranch = "default"
+
+// Changeset is a parsed changeset revision.
+type Changeset struct {
+	Manifest revlog.Node
+	User     string
+	// Date is the commit time in the time zone of the committer.
+	Date time.Time
+	// Extra always has a "branch" item.
+	Extra       map[string]string
+	Files       []string
+	Description string
+}
+
+// Branch returns the name of the branch of the changeset.
+func (c *Changeset) Branch() string {
+	return c.Extra["branch"]
+}
+
+// Closed reports whether the changeset closes its branch.
+func (c *Changeset) Closed() bool {
+	_, ok := c.Extra["close"]
+	return ok
+}
+
+func corrupt(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+}
+
+// Parse decodes a changeset revision text.
+//
+// Translation of changelog.changelogrevision.
+func Parse(text []byte) (*Changeset, error) {
+	c := &Changeset{Extra: map[string]string{"branch": DefaultBranch}}
+	if len(text) == 0 {
+		c.Date = time.Unix(0, 0).In(time.FixedZone("", 0))
+		return c, nil
+	}
+
+	lines := bytes.SplitN(text, []byte("\n"), 4)
+	if len(lines) < 4 {
+		return nil, corrupt("truncated header")
+	}
+
+	if len(lines[0]) != hex.EncodedLen(len(c.Manifest)) {
+		return nil, corrupt("invalid manifest node")
+	}
+	if _, err := hex.Decode(c.Manifest[:], lines[0]); err != nil {
+		return nil, corrupt("invalid manifest node")
+	}
+	c.User = string(lines[1])
+
+	fields := strings.SplitN(string(lines[2]), " ", 3)
+	if len(fields) < 2 {
+		return nil, corrupt("invalid date '%s'", lines[2])
+	}
+	date, err := parseDate(fields[0], fields[1])
+	if err != nil {
+		return nil, err
+	}
+	c.Date = date
+	if len(fields) == 3 {
+		if err := decodeExtra(fields[2], c.Extra); err != nil {
+			return nil, err
+		}
+	}
+
+	// The files are followed by an empty line, which directly follows
+	// the date line when there are no files.
+	rest := lines[3]
+	if bytes.HasPrefix(rest, []byte("\n")) {
+		c.Description = string(rest[1:])
+		return c, nil
+	}
+	end := bytes.Index(rest, []byte("\n\n"))
+	if end < 0 {
+		return nil, corrupt("missing description")
+	}
+	c.Files = strings.Split(string(rest[:end]), "\n")
+	c.Description = string(rest[end+2:])
+	return c, nil
+}
+
+// parseDate reads a unix time, which may have a fractional part, and a timezone offset.
+// Like Mercurial, an invalid offset is taken for UTC.
+func parseDate(unix, tz string) (time.Time, error) {
+	secs, err := strconv.ParseFloat(unix, 64)
+	if err != nil || math.IsInf(secs, 0) || math.IsNaN(secs) {
+		return time.Time{}, corrupt("invalid date '%s %s'", unix, tz)
+	}
+	offset, err := strconv.Atoi(tz)
+	if err != nil {
+		offset = 0
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)).In(time.FixedZone("", -offset)), nil
+}
+
+// decodeExtra adds the items of an encoded extra to extra.
+//
+// Translation of changelog.decodeextra.
+func decodeExtra(text string, extra map[string]string) error {
+	for _, item := range strings.Split(text, "\x00") {
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, `\0`) {
+			// Turn escaped NULs into NULs without touching escaped backslashes followed by "0".
+			item = strings.Replace(item, `\\`, "\\\\\n", -1)
+			item = strings.Replace(item, `\0`, "\x00", -1)
+			item = strings.Replace(item, "\n", "", -1)
+		}
+		s, err := unescape(item)
+		if err != nil {
+			return corrupt("invalid extra '%s': %v", item, err)
+		}
+		i := strings.IndexByte(s, ':')
+		if i < 0 {
+			return corrupt("invalid extra '%s'", item)
+		}
+		extra[s[:i]] = s[i+1:]
+	}
+	return nil
+}
+
+var simpleEscapes = map[byte]byte{
+	'\\': '\\', '\'': '\'', '"': '"',
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+// unescape decodes the backslash escapes of Python byte string literals,
+// like stringutil.unescapestr. Unknown escapes are kept as they are.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New(`trailing \ in string`)
+		}
+		c := s[i]
+		switch {
+		case simpleEscapes[c] != 0:
+			b.WriteByte(simpleEscapes[c])
+		case c == '\n':
+			// An escaped line break is removed.
+		case c >= '0' && c <= '7':
+			v := 0
+			j := i
+			for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+				v = v*8 + int(s[j]-'0')
+			}
+			b.WriteByte(byte(v))
+			i = j - 1
+		case c == 'x':
+			if i+3 > len(s) {
+				return "", fmt.Errorf(`invalid \x escape at position %d`, i-1)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf(`invalid \x escape at position %d`, i-1)
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// Changelog is the revlog storing the changesets of a repository.
+type Changelog struct {
+	*revlog.Revlog
+}
+
+// Open opens the changelog stored at indexPath. With pending set, the index
+// of an unfinished transaction, indexPath with an ".a" suffix, is read instead
+// when it exists. Only its index is pending: the data file is shared.
+func Open(indexPath string, pending bool) (*Changelog, error) {
+	dataPath := strings.TrimSuffix(indexPath, ".i") + ".d"
+	if pending {
+		if _, err := os.Stat(indexPath + ".a"); err == nil {
+			indexPath += ".a"
+		}
+	}
+	rl, err := revlog.OpenFiles(indexPath, dataPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Changelog{rl}, nil
+}
+
+// Read returns the changeset rev.
+func (c *Changelog) Read(rev revlog.Rev) (*Changeset, error) {
+	if rev == revlog.NullRev {
+		return Parse(nil)
+	}
+	text, err := c.Revision(rev)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := Parse(text)
+	if err != nil {
+		// Revision succeeded, so rev is known.
+		node, _ := c.Node(rev)
+		return nil, fmt.Errorf("changeset %s: %w", node, err)
+	}
+	return cs, nil
+}
+
+// ReadNode returns the changeset node.
+func (c *Changelog) ReadNode(node revlog.Node) (*Changeset, error) {
+	if node == revlog.NullNode {
+		return Parse(nil)
+	}
+	rev, err := c.Rev(node)
+	if err != nil {
+		return nil, err
+	}
+	return c.Read(rev)
+}
@@ -0,0 +1,201 @@
+package changelog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sashka/hgo/revlog"
+)
+
+const manifestHex = "4e9c1ca3a166d3be8aad3d338a8981e87a9e6888"
+
+func TestParse(t *testing.T) {
+	text := manifestHex + "\n" +
+		"Jane Doe <jane@example.com>\n" +
+		"1500000000 -7200 branch:stable\x00close:1\x00source:abc\n" +
+		"a.txt\n" +
+		"dir/b.txt\n" +
+		"\n" +
+		"fix things\n\nin two paragraphs"
+	cs, err := Parse([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cs.Manifest.String() != manifestHex {
+		t.Errorf("Manifest = %s, want %s", cs.Manifest, manifestHex)
+	}
+	if cs.User != "Jane Doe <jane@example.com>" {
+		t.Errorf("User = %q", cs.User)
+	}
+	if _, offset := cs.Date.Zone(); cs.Date.Unix() != 1500000000 || offset != 7200 {
+		t.Errorf("Date = %v, want 1500000000 at UTC+2", cs.Date)
+	}
+	wantExtra := map[string]string{"branch": "stable", "close": "1", "source": "abc"}
+	if !reflect.DeepEqual(cs.Extra, wantExtra) {
+		t.Errorf("Extra = %v, want %v", cs.Extra, wantExtra)
+	}
+	if cs.Branch() != "stable" || !cs.Closed() {
+		t.Errorf("Branch() = %q, Closed() = %v", cs.Branch(), cs.Closed())
+	}
+	if want := []string{"a.txt", "dir/b.txt"}; !reflect.DeepEqual(cs.Files, want) {
+		t.Errorf("Files = %q, want %q", cs.Files, want)
+	}
+	if cs.Description != "fix things\n\nin two paragraphs" {
+		t.Errorf("Description = %q", cs.Description)
+	}
+}
+
+func TestParseNoFiles(t *testing.T) {
+	cs, err := Parse([]byte(manifestHex + "\nuser\n1500000000.25 bad\n\nmerge\n\nwith empty lines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Files != nil {
+		t.Errorf("Files = %q, want none", cs.Files)
+	}
+	if cs.Description != "merge\n\nwith empty lines" {
+		t.Errorf("Description = %q", cs.Description)
+	}
+	// An invalid timezone is UTC.
+	if _, offset := cs.Date.Zone(); offset != 0 || cs.Date.UnixNano() != 1500000000250000000 {
+		t.Errorf("Date = %v", cs.Date)
+	}
+	if cs.Branch() != DefaultBranch || cs.Closed() {
+		t.Errorf("Branch() = %q, Closed() = %v", cs.Branch(), cs.Closed())
+	}
+}
+
+func TestParseNull(t *testing.T) {
+	cs, err := Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Manifest != revlog.NullNode || cs.Date.Unix() != 0 || cs.Branch() != DefaultBranch {
+		t.Errorf("null changeset = %+v", cs)
+	}
+}
+
+func TestParseCorrupt(t *testing.T) {
+	for _, text := range []string{
+		manifestHex + "\nuser\n",
+		"4e9c\nuser\n0 0\n\n",
+		manifestHex + "\nuser\nnow 0\n\n",
+		manifestHex + "\nuser\n0\n\n",
+		manifestHex + "\nuser\n0 0\na.txt\n",
+		manifestHex + "\nuser\n0 0 novalue\n\n",
+		manifestHex + "\nuser\n0 0 key:trailing\\\n\n",
+	} {
+		if _, err := Parse([]byte(text)); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Parse(%q) error = %v, want ErrCorrupt", text, err)
+		}
+	}
+}
+
+// Extras as written by changelog.encodeextra.
+func TestDecodeExtra(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		{`convert_revision:svn:repo/trunk@42`, map[string]string{"convert_revision": "svn:repo/trunk@42"}},
+		{`key:line\nbreak\r\\`, map[string]string{"key": "line\nbreak\r\\"}},
+		{`key:nul\0byte`, map[string]string{"key": "nul\x00byte"}},
+		{`key:backslash\\0zero`, map[string]string{"key": `backslash\0zero`}},
+		{`key:nul\01`, map[string]string{"key": "nul\x001"}},
+		{`key:\x41\101\q`, map[string]string{"key": `AA\q`}},
+		{"a:1\x00\x00b:2", map[string]string{"a": "1", "b": "2"}},
+	}
+	for _, tt := range tests {
+		extra := make(map[string]string)
+		if err := decodeExtra(tt.text, extra); err != nil {
+			t.Errorf("decodeExtra(%q) error: %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(extra, tt.want) {
+			t.Errorf("decodeExtra(%q) = %q, want %q", tt.text, extra, tt.want)
+		}
+	}
+}
+
+// writeChangelog writes an inline changelog of uncompressed full texts.
+func writeChangelog(t *testing.T, path string, texts ...string) []revlog.Node {
+	t.Helper()
+	var buf bytes.Buffer
+	var nodes []revlog.Node
+	var offset int
+	p1 := revlog.NullNode
+	for i, text := range texts {
+		chunk := "u" + text
+		node := revlog.Hash([]byte(text), p1, revlog.NullNode)
+		entry := make([]byte, 64)
+		binary.BigEndian.PutUint64(entry[0:8], uint64(offset)<<16)
+		binary.BigEndian.PutUint32(entry[8:12], uint32(len(chunk)))
+		binary.BigEndian.PutUint32(entry[12:16], uint32(len(text)))
+		binary.BigEndian.PutUint32(entry[16:20], uint32(i))
+		binary.BigEndian.PutUint32(entry[20:24], uint32(i))
+		binary.BigEndian.PutUint32(entry[24:28], uint32(i-1))
+		binary.BigEndian.PutUint32(entry[28:32], 0xffffffff)
+		copy(entry[32:52], node[:])
+		if i == 0 {
+			// Version 1, inline data.
+			binary.BigEndian.PutUint32(entry[0:4], 1|1<<16)
+		}
+		buf.Write(entry)
+		buf.WriteString(chunk)
+		nodes = append(nodes, node)
+		offset += len(chunk)
+		p1 = node
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+func TestOpenPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := manifestHex + "\nuser\n0 0\na.txt\n\nfirst"
+	second := manifestHex + "\nuser\n3600 -3600\nb.txt\n\nsecond"
+	indexPath := filepath.Join(dir, "00changelog.i")
+	writeChangelog(t, indexPath, first)
+	nodes := writeChangelog(t, indexPath+".a", first, second)
+
+	cl, err := Open(indexPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", cl.Len())
+	}
+
+	cl, err = Open(indexPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Len() != 2 {
+		t.Fatalf("pending Len() = %d, want 2", cl.Len())
+	}
+	cs, err := cl.ReadNode(nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Description != "second" || !cs.Date.Equal(time.Unix(3600, 0)) {
+		t.Errorf("pending changeset = %+v", cs)
+	}
+	if cs, err := cl.Read(revlog.NullRev); err != nil || cs.Manifest != revlog.NullNode {
+		t.Errorf("Read(NullRev) = %+v, %v", cs, err)
+	}
+}
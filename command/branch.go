@@ -22,7 +22,7 @@ func (c *BranchCommand) Run(args []string) int {
 
 	repo, err := repo.Open(wd)
 	if err != nil {
-		return Abort("%s!\n", err)
+		return AbortErr(err)
 	}
 
 	// All the previous code ^^^ to be removed completely on stage 1.
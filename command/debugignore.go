@@ -0,0 +1,95 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashka/hgo/match"
+	"github.com/sashka/hgo/repo"
+)
+
+type DebugIgnoreCommand struct {
+}
+
+func (c *DebugIgnoreCommand) Run(args []string) int {
+	wd, err := os.Getwd()
+	if err != nil {
+		return Abort("error getting current working directory: %s", err)
+	}
+
+	repo, err := repo.Open(wd)
+	if err != nil {
+		return AbortErr(err)
+	}
+	if _, err := repo.Config(); err != nil {
+		return AbortParseError(err)
+	}
+
+	// All the previous code ^^^ to be removed completely on stage 1.
+
+	ignore, err := repo.Ignore()
+	if err != nil {
+		return Abort("%s!\n", err)
+	}
+
+	if len(args) == 0 {
+		fmt.Println(ignore)
+		return 0
+	}
+
+	for _, f := range args {
+		nf, err := match.CanonPath(repo.RootDir, wd, f)
+		if err != nil {
+			return Abort("%s\n", err)
+		}
+		f = filepath.Clean(f)
+
+		// Look for the file itself, then for its directories from the deepest one.
+		var ignored string
+		var pat *match.Pattern
+		for p := nf; p != ""; p = parentDir(p) {
+			if ignore.Match(p) {
+				ignored = p
+				pat, _ = ignore.Explain(p)
+				break
+			}
+		}
+
+		if ignored == "" {
+			fmt.Printf("%s is not ignored\n", f)
+			continue
+		}
+		if ignored == nf {
+			fmt.Printf("%s is ignored\n", f)
+		} else {
+			fmt.Printf("%s is ignored because of containing directory %s\n", f, ignored)
+		}
+		if pat != nil {
+			fmt.Printf("(ignore rule in %s, line %d: '%s')\n", pat.Source, pat.Lineno, pat.Original)
+		}
+	}
+
+	return 0
+}
+
+func (c *DebugIgnoreCommand) Synopsis() string {
+	return "display the combined ignore pattern and information about ignored files"
+}
+
+func (c *DebugIgnoreCommand) Help() string {
+	helpText := `
+Usage: hgo debugignore [FILE...]
+
+Display the combined ignore pattern and information about ignored files.
+
+With no argument display the combined ignore pattern.
+
+Given space separated file names, shows if the given file is ignored and
+if so, show the ignore rule (file and line number) that matched it.
+
+Returns 0 on success.
+	`
+	return strings.TrimSpace(helpText)
+}
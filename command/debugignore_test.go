@@ -0,0 +1,44 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDebugIgnore(t *testing.T) {
+	defer noUserConfig()()
+	root, err := ioutil.TempDir("", "debugignore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		".hg/requires": "revlogv1\nstore\n",
+		".hgignore":    "syntax: glob\n*.o\n\nsyntax: regexp\n^build/\n^out$\n",
+	})
+	defer chdir(t, root)()
+
+	// Without arguments, the whole matcher is printed.
+	got := captureOutput(t, &os.Stdout, func() { (&DebugIgnoreCommand{}).Run(nil) })
+	if want := "<includematcher includes='.*\\.o(?:/|$)|^build/|^out$'>\n"; got != want {
+		t.Errorf("debugignore printed %q, want %q", got, want)
+	}
+
+	// Files are attributed the rule that ignores them or their directory.
+	hgignore := filepath.Join(root, ".hgignore")
+	got = captureOutput(t, &os.Stdout, func() {
+		(&DebugIgnoreCommand{}).Run([]string{"a.o", "build/x/y.c", "out/a.c", "a.c"})
+	})
+	want := "a.o is ignored\n" +
+		"(ignore rule in " + hgignore + ", line 2: '*.o')\n" +
+		"build/x/y.c is ignored\n" +
+		"(ignore rule in " + hgignore + ", line 5: '^build/')\n" +
+		"out/a.c is ignored because of containing directory out\n" +
+		"(ignore rule in " + hgignore + ", line 6: '^out$')\n" +
+		"a.c is not ignored\n"
+	if got != want {
+		t.Errorf("debugignore with files printed:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashka/hgo/repo"
+)
+
+type DebugRequirementsCommand struct {
+}
+
+func (c *DebugRequirementsCommand) Run(args []string) int {
+	wd, err := os.Getwd()
+	if err != nil {
+		return Abort("error getting current working directory: %s", err)
+	}
+
+	repo, err := repo.Open(wd)
+	if err != nil {
+		return AbortErr(err)
+	}
+
+	// All the previous code ^^^ to be removed completely on stage 1.
+
+	for _, req := range repo.SortedRequirements() {
+		fmt.Println(req)
+	}
+	return 0
+}
+
+func (c *DebugRequirementsCommand) Synopsis() string {
+	return "print the current repo requirements"
+}
+
+func (c *DebugRequirementsCommand) Help() string {
+	helpText := `
+Usage: hgo debugrequirements
+
+Print the requirements of the repository, from .hg/requires and, for
+share-safe repositories, from the requires file of the store.
+
+Returns 0 on success.
+	`
+	return strings.TrimSpace(helpText)
+}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// statusWriter prints status entries as lines of text or as a JSON list.
+type statusWriter struct {
+	w    *bufio.Writer
+	json bool
+
+	// nostatus leaves the status letters out of text output.
+	nostatus bool
+
+	// uipath turns the paths of text output into the paths shown.
+	// JSON output always has paths relative to the repository root.
+	uipath func(string) string
+
+	// end terminates lines of text: "\n", or "\x00" with --print0.
+	end   string
+	items int
+}
+
+func newStatusWriter(w io.Writer, json, nostatus, print0 bool, uipath func(string) string) *statusWriter {
+	sw := &statusWriter{w: bufio.NewWriter(w), json: json, nostatus: nostatus, uipath: uipath, end: "\n"}
+	if print0 {
+		sw.end = "\x00"
+	}
+	if json {
+		sw.w.WriteString("[")
+	}
+	return sw
+}
+
+// write prints the entry of path with status code. source is the copy source of path
+// and unsure why its stat data was not conclusive, both "" when there is none.
+func (sw *statusWriter) write(code, path, source, unsure string) {
+	if !sw.json {
+		if !sw.nostatus {
+			sw.w.WriteString(code + " ")
+		}
+		sw.w.WriteString(sw.uipath(path) + sw.end)
+		if source != "" {
+			sw.w.WriteString("  " + sw.uipath(source) + sw.end)
+		}
+		return
+	}
+
+	// JSON always has the status: --no-status only shortens lines of text.
+	item := map[string]string{"itemtype": "file", "path": path, "status": code}
+	if source != "" {
+		item["source"] = source
+	}
+	if unsure != "" {
+		item["unsure"] = unsure
+	}
+	sw.writeItem(item)
+}
+
+// comment prints msg with each line commented out by "# ", then a blank line.
+// JSON output has no room for it.
+//
+// Mechanical translation of cmdutil._commentlines.
+func (sw *statusWriter) comment(msg string) {
+	if sw.json {
+		return
+	}
+	for _, l := range strings.Split(msg, "\n") {
+		sw.w.WriteString("# " + l + "\n")
+	}
+	sw.w.WriteString("\n")
+}
+
+// writeItem prints a JSON object the way Mercurial's jsonformatter does:
+// one key per line, keys sorted.
+func (sw *statusWriter) writeItem(item map[string]string) {
+	if sw.items > 0 {
+		sw.w.WriteString(",")
+	}
+	sw.items++
+
+	keys := make([]string, 0, len(item))
+	for k := range item {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sw.w.WriteString("\n {\n")
+	for i, k := range keys {
+		if i > 0 {
+			sw.w.WriteString(",\n")
+		}
+		sw.w.WriteString("  " + jsonString(k) + ": " + jsonString(item[k]))
+	}
+	sw.w.WriteString("\n }")
+}
+
+// Close ends the output and flushes it.
+func (sw *statusWriter) Close() error {
+	if sw.json {
+		sw.w.WriteString("\n]\n")
+	}
+	return sw.w.Flush()
+}
+
+// jsonString quotes s as a JSON string. Invalid UTF-8 is replaced by U+FFFD.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
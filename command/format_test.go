@@ -0,0 +1,102 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeStatus prints the same entries and comment with a statusWriter set up with the options given.
+func writeStatus(t *testing.T, jsonOutput, nostatus, print0 bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	uipath := func(f string) string { return "../" + f }
+	sw := newStatusWriter(&buf, jsonOutput, nostatus, print0, uipath)
+	sw.write("M", "a.txt", "", "mtime changed")
+	sw.write("A", "dir/b.txt", "a.txt", "")
+	sw.write("?", "c d.txt", "", "")
+	sw.comment("The repository is in an unfinished *merge* state.")
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestStatusWriterText(t *testing.T) {
+	tests := []struct {
+		nostatus, print0 bool
+		want             string
+	}{
+		{false, false, "M ../a.txt\nA ../dir/b.txt\n  ../a.txt\n? ../c d.txt\n"},
+		{true, false, "../a.txt\n../dir/b.txt\n  ../a.txt\n../c d.txt\n"},
+		{false, true, "M ../a.txt\x00A ../dir/b.txt\x00  ../a.txt\x00? ../c d.txt\x00"},
+		{true, true, "../a.txt\x00../dir/b.txt\x00  ../a.txt\x00../c d.txt\x00"},
+	}
+
+	comment := "# The repository is in an unfinished *merge* state.\n\n"
+	for _, tt := range tests {
+		if got := writeStatus(t, false, tt.nostatus, tt.print0); got != tt.want+comment {
+			t.Errorf("nostatus=%v print0=%v: got %q, want %q", tt.nostatus, tt.print0, got, tt.want+comment)
+		}
+	}
+}
+
+func TestStatusWriterJSON(t *testing.T) {
+	want := []map[string]string{
+		{"itemtype": "file", "path": "a.txt", "status": "M", "unsure": "mtime changed"},
+		{"itemtype": "file", "path": "dir/b.txt", "status": "A", "source": "a.txt"},
+		{"itemtype": "file", "path": "c d.txt", "status": "?"},
+	}
+
+	// Paths stay relative to the root, and neither --no-status nor --print0 change JSON.
+	for _, nostatus := range []bool{false, true} {
+		for _, print0 := range []bool{false, true} {
+			out := writeStatus(t, true, nostatus, print0)
+			var got []map[string]string
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("nostatus=%v print0=%v: invalid JSON %q: %v", nostatus, print0, out, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("nostatus=%v print0=%v: got %v, want %v", nostatus, print0, got, want)
+			}
+		}
+	}
+}
+
+func TestStatusWriterJSONLayout(t *testing.T) {
+	var buf bytes.Buffer
+	sw := newStatusWriter(&buf, true, false, false, nil)
+	sw.write("R", "caf\xe9\"x\".txt", "", "")
+	sw.writeItem(map[string]string{"itemtype": "morestatus", "unfinished": "merge"})
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"[",
+		" {",
+		`  "itemtype": "file",`,
+		`  "path": "caf�\"x\".txt",`,
+		`  "status": "R"`,
+		" },",
+		" {",
+		`  "itemtype": "morestatus",`,
+		`  "unfinished": "merge"`,
+		" }",
+		"]",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := newStatusWriter(&buf, true, false, false, nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[\n]\n" {
+		t.Errorf("empty list = %q, want \"[\\n]\\n\"", got)
+	}
+}
@@ -0,0 +1,118 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashka/hgo/match"
+	"github.com/sashka/hgo/mergestate"
+	"github.com/sashka/hgo/repo"
+)
+
+// ResolveCommand is a Command that lists the files of an unfinished merge.
+type ResolveCommand struct {
+}
+
+// resolveCodes are the codes resolve --list shows for each file state.
+var resolveCodes = map[mergestate.FileState]string{
+	mergestate.Unresolved:     "U",
+	mergestate.Resolved:       "R",
+	mergestate.UnresolvedPath: "P",
+	mergestate.ResolvedPath:   "R",
+	mergestate.DriverResolved: "R",
+}
+
+func (c *ResolveCommand) Run(args []string) int {
+	var list bool
+	var nostatus bool
+	var pats []string
+	for i, arg := range args {
+		if arg == "--" {
+			pats = append(pats, args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "-l" || arg == "--list":
+			list = true
+		case arg == "-n" || arg == "--no-status":
+			nostatus = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return Abort("unknown option %s\n", arg)
+		default:
+			pats = append(pats, arg)
+		}
+	}
+
+	if !list {
+		return Abort("only resolve --list is supported\n")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return Abort("error getting current working directory: %s", err)
+	}
+
+	repo, err := repo.Open(wd)
+	if err != nil {
+		return AbortErr(err)
+	}
+	if _, err := repo.Config(); err != nil {
+		return AbortParseError(err)
+	}
+
+	// All the previous code ^^^ to be removed completely on stage 1.
+
+	files, err := match.NewFileMatcher(repo.RootDir, wd, pats)
+	if err != nil {
+		return Abort("%s\n", err)
+	}
+	ms, err := repo.MergeState()
+	if err != nil {
+		return Abort("%s\n", err)
+	}
+	uipath, err := uiPathFunc(repo, wd, true, "")
+	if err != nil {
+		return Abort("%s\n", err)
+	}
+
+	for _, f := range ms.Paths() {
+		if !files.MatchFile(f, match.FileInfo{}) {
+			continue
+		}
+		if nostatus {
+			fmt.Println(uipath(f))
+		} else {
+			fmt.Println(resolveCodes[ms.Files[f].State], uipath(f))
+		}
+	}
+
+	return 0
+}
+
+func (c *ResolveCommand) Synopsis() string {
+	return "list the files of an unfinished merge"
+}
+
+func (c *ResolveCommand) Help() string {
+	helpText := `
+Usage: hgo resolve --list [OPTION]... [FILE]...
+
+List the files that had conflicts during the last merge, update, rebase,
+graft or similar operation, and whether they were resolved.
+
+Options:
+
+	-l, --list       list the state of the files (required)
+	-n, --no-status  hide the status codes
+
+The codes used to show the state of files are:
+
+	U = unresolved
+	R = resolved
+	P = unresolved path conflict
+
+Returns 0 on success.
+	`
+	return strings.TrimSpace(helpText)
+}
@@ -0,0 +1,123 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashka/hgo/repo"
+)
+
+// captureOutput runs f with *out, os.Stdout or os.Stderr, redirected to a file
+// and returns what f wrote there.
+func captureOutput(t *testing.T, out **os.File, f func()) string {
+	t.Helper()
+	tmp, err := ioutil.TempFile("", "hgo-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	saved := *out
+	*out = tmp
+	f()
+	*out = saved
+
+	data, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// chdir changes the working directory to dir and returns a function changing it back.
+func chdir(t *testing.T, dir string) func() {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.Chdir(wd) }
+}
+
+// newMergeRepo creates a repository in the middle of a merge, whose merge state
+// testdata/state2 lists an unresolved content conflict in a.txt, a resolved
+// change/delete conflict in b.txt and an unresolved path conflict in dir/c.txt.
+func newMergeRepo(t *testing.T) string {
+	t.Helper()
+	state, err := ioutil.ReadFile(filepath.Join("testdata", "state2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := ioutil.TempDir("", "resolve-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{
+		".hg/requires":     "revlogv1\nstore\n",
+		".hg/merge/state2": string(state),
+		"a.txt":            "a\n",
+		"dir/c.txt":        "c\n",
+	})
+	return root
+}
+
+func TestResolveList(t *testing.T) {
+	root := newMergeRepo(t)
+	defer os.RemoveAll(root)
+	defer chdir(t, root)()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--list"}, "U a.txt\nR b.txt\nP dir/c.txt\n"},
+		{[]string{"-l", "-n"}, "a.txt\nb.txt\ndir/c.txt\n"},
+		{[]string{"-l", "dir"}, "P dir/c.txt\n"},
+	}
+	for _, tt := range tests {
+		var code int
+		got := captureOutput(t, &os.Stdout, func() {
+			code = (&ResolveCommand{}).Run(tt.args)
+		})
+		if code != 0 {
+			t.Errorf("resolve %q exited with %d", tt.args, code)
+		}
+		if got != tt.want {
+			t.Errorf("resolve %q printed %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	// Paths are relative to the current directory.
+	defer chdir(t, filepath.Join(root, "dir"))()
+	if got, want := captureOutput(t, &os.Stdout, func() { (&ResolveCommand{}).Run([]string{"-l"}) }), "U ../a.txt\nR ../b.txt\nP c.txt\n"; got != want {
+		t.Errorf("resolve -l in dir printed %q, want %q", got, want)
+	}
+}
+
+func TestWarnUnresolved(t *testing.T) {
+	root := newMergeRepo(t)
+	defer os.RemoveAll(root)
+
+	r, err := repo.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := captureOutput(t, &os.Stderr, func() { warnUnresolved(r) })
+	if want := "warning: 2 unresolved merge conflicts (see 'hgo resolve --list')\n"; got != want {
+		t.Errorf("warnUnresolved printed %q, want %q", got, want)
+	}
+
+	// Nothing is printed without a merge.
+	if err := os.Remove(r.Join("merge", "state2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := captureOutput(t, &os.Stderr, func() { warnUnresolved(r) }); got != "" {
+		t.Errorf("warnUnresolved without a merge printed %q", got)
+	}
+}
@@ -20,7 +20,7 @@ func (c *RootCommand) Run(args []string) int {
 
 	repo, err := repo.Open(wd)
 	if err != nil {
-		return Abort("%s!\n", err)
+		return AbortErr(err)
 	}
 
 	fmt.Println(repo.RootDir)
@@ -1,271 +1,158 @@
 package command
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-
+	"sync"
 	"time"
 
-	"regexp"
-
 	radix "github.com/armon/go-radix"
+	"github.com/sashka/hgo/dirstate"
+	"github.com/sashka/hgo/manifest"
+	"github.com/sashka/hgo/match"
 	"github.com/sashka/hgo/repo"
+	"github.com/sashka/hgo/revlog"
 )
 
 // StatusCommand is a Command that show status of all files.
 type StatusCommand struct {
 }
 
-func printSlice(print bool, prefix string, slice []string, nostatus bool) {
+func printSlice(sw *statusWriter, print bool, prefix string, slice []string, copies, unsure map[string]string) {
 	if !print || len(slice) == 0 {
 		return
 	}
 
 	for _, s := range slice {
-		if nostatus {
-			fmt.Println(s)
-		} else {
-			fmt.Println(prefix, s)
-		}
+		sw.write(prefix, s, copies[s], unsure[s])
 	}
 }
 
-// Syntax xxx.
-type Syntax int
+// ignorer tells whether paths are ignored: a path is ignored when it
+// or any of its parent directories matches an ignore pattern.
+// It is safe for concurrent use.
+type ignorer struct {
+	matcher *match.Matcher
 
-// Syntax types
-const (
-	Glob Syntax = iota
-	Regexp
-)
-
-type pattern struct {
-	syntax   Syntax
-	pattern  string
-	lineno   int
-	original string
-	re       *regexp.Regexp
+	mu   sync.Mutex
+	dirs map[string]bool
 }
 
-// Mechanical translation of match._globre:
-func globre(s string) string {
-	i := 0
-	n := len(s)
-	group := 0
-	var res bytes.Buffer
-
-	for i < n {
-		c := s[i : i+1]
-		i++
-
-		if c != "*" && c != "?" && c != "[" && c != "{" && c != "}" && c != "," && c != "\\" {
-			res.WriteString(regexp.QuoteMeta(c))
-		} else if c == "*" {
-			if i < n && s[i:i+1] == "*" {
-				i++
-				if i < n && s[i:i+1] == "/" {
-					i++
-					res.WriteString("(?:.*/)?")
-				} else {
-					res.WriteString(".*")
-				}
-			} else {
-				res.WriteString("[^/]*")
-			}
-		} else if c == "?" {
-			res.WriteString(".")
-		} else if c == "[" {
-			j := i
-			if j < n && (s[j:j+1] == "!" || s[j:j+1] == "]") {
-				j++
-			}
-			for j < n && s[j:j+1] != "]" {
-				j++
-			}
-			if j >= n {
-				res.WriteString("\\[")
-			} else {
-				stuff := strings.Replace(s[i:j], "\\", "\\\\", -1)
-				i = j + 1
-				if stuff[0:1] == "!" {
-					stuff = "^" + stuff[1:]
-				} else if stuff[0:1] == "^" {
-					stuff = "\\" + stuff
-				}
-				res.WriteString("[" + stuff + "]")
-			}
-		} else if c == "{" {
-			group++
-			res.WriteString("(?:")
-		} else if c == "}" && group > 0 {
-			res.WriteString(")")
-			group--
-		} else if c == "," && group > 0 {
-			res.WriteString("|")
-		} else if c == "\\" {
-			if i < n {
-				i++
-				res.WriteString(regexp.QuoteMeta(s[i : i+1]))
-			} else {
-				res.WriteString(regexp.QuoteMeta(c))
-			}
-		} else {
-			res.WriteString(regexp.QuoteMeta(c))
-		}
-	}
-
-	return res.String()
+func newIgnorer(matcher *match.Matcher) *ignorer {
+	return &ignorer{matcher: matcher, dirs: make(map[string]bool)}
 }
 
-// parse a pattern file, returning a list of
-// patterns. These patterns should be given to compile()
-// to be validated and converted into a match function.
-//
-// trailing white space is dropped.
-// the escape character is backslash.
-// comments start with #.
-// empty lines are skipped.
-//
-// lines can be of the following formats:
-//
-// syntax: regexp # defaults following lines to non-rooted regexps
-// syntax: glob   # defaults following lines to non-rooted globs
-// re:pattern     # non-rooted regular expression
-// glob:pattern   # non-rooted glob
-// pattern        # pattern of the current default type
-//
-// if sourceinfo is set, returns a list of tuples:
-// (pattern, lineno, originalline). This is useful to debug ignore patterns.
-func parseHgIgnore(filepath string) ([]pattern, error) {
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+// dir reports whether the directory dir is ignored as a whole.
+func (ig *ignorer) dir(dir string) bool {
+	if dir == "" {
+		return false
 	}
 
-	currentSyntax := Glob
-	commentre := regexp.MustCompile("((?:^|[^\\\\])(?:\\\\\\\\)*)#.*")
-
-	// read line by line
-	f, err := os.Open(filepath)
-	if err != nil {
-		return nil, err
+	ig.mu.Lock()
+	ignored, ok := ig.dirs[dir]
+	ig.mu.Unlock()
+	if ok {
+		return ignored
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	scanner.Split(bufio.ScanLines)
-
-	lineno := 1
-	pats := make([]pattern, 0)
-
-	for scanner.Scan() {
-		s := strings.TrimSpace(scanner.Text())
-		lineno++
-
-		if s == "" {
-			continue
-		}
 
-		original := s
-
-		// Strip comment
-		if strings.Contains(s, "#") {
-			loc := commentre.FindStringIndex(s)
-			if len(loc) > 0 {
-				s = strings.TrimSpace(s[:loc[0]])
-			}
-			s = strings.Replace(s, "\\#", "#", -1)
-		}
-
-		if s == "" {
-			continue
-		}
+	ignored = ig.matcher.Match(dir) || ig.dir(parentDir(dir))
 
-		if strings.HasPrefix(s, "syntax:") {
-			s := strings.TrimSpace(s[7:])
-			if s == "" {
-				continue
-			}
-
-			switch s {
-			case "glob":
-				currentSyntax = Glob
-			case "regexp":
-				currentSyntax = Regexp
-			default:
-				return nil, fmt.Errorf("%s: invalid syntax '%s'", filepath, s)
-			}
-			continue
-		}
-
-		pat := pattern{
-			syntax:   currentSyntax,
-			lineno:   lineno,
-			original: original,
-			pattern:  s,
-		}
-
-		pats = append(pats, pat)
-	}
+	ig.mu.Lock()
+	ig.dirs[dir] = ignored
+	ig.mu.Unlock()
+	return ignored
+}
 
-	if err := scanner.Err(); err != nil {
-		// Handle the error
-		return nil, err
-	}
+// file reports whether the file at path is ignored.
+func (ig *ignorer) file(path string) bool {
+	return ig.matcher.Match(path) || ig.dir(parentDir(path))
+}
 
-	// Compile regexps
-	for i := range pats {
-		switch pats[i].syntax {
-		case Glob:
-			// Convert an extended glob string to a regexp string.
-			pats[i].re = regexp.MustCompile(globre(pats[i].pattern))
-		case Regexp:
-			pats[i].re = regexp.MustCompile(pats[i].pattern)
-		}
+// parentDir returns the directory part of a slash-separated path, "" for top-level files.
+func parentDir(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
 	}
-
-	return pats, nil
+	return ""
 }
 
-func shouldIgnore(s string, pats []pattern) bool {
-	if len(pats) == 0 {
-		return false
-	}
-	for i := range pats {
-		if pats[i].re.MatchString(s) {
-			return true
-		}
-	}
-	return false
+// statusLists holds the files of each status, sorted.
+type statusLists struct {
+	modified []string
+	added    []string
+	removed  []string
+	deleted  []string
+	unknown  []string
+	ignored  []string
+	clean    []string
+
+	// parent is the first parent of the working directory, stats maps the files
+	// found in it to their stat data, nil for missing ones, and dirCopies maps
+	// files to the copy sources recorded in the dirstate.
+	// unsure maps the files whose stat data was not conclusive, and that
+	// were compared by content, to the reason why.
+	// They are only set by workingStatus.
+	parent    revlog.Node
+	stats     *radix.Tree
+	dirCopies map[string]string
+	unsure    map[string]string
+
+	// subCopies maps the files of subrepositories to their copy sources,
+	// both prefixed with the subrepository path. Set by addSubrepoStatus.
+	subCopies map[string]string
 }
 
 func (c *StatusCommand) Run(args []string) int {
-	listdeleted := true
-	listmodified := true
-	listunknown := true
+	var listdeleted bool
+	var listmodified bool
+	var listunknown bool
 	var listadded bool
 	var listclean bool
 	var listignored bool
 	var listremoved bool
 	var nostatus bool
+	var listcopies bool
+	var terse string
+	var print0 bool
+	var template string
+	var subrepos bool
+	var verbose bool
+	var revs []string
+	var change string
+
+	var pats []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			pats = append(pats, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(args[i], "-") || args[i] == "-" {
+			pats = append(pats, args[i])
+			continue
+		}
+		if strings.HasPrefix(args[i], "-T") && len(args[i]) > 2 {
+			template = args[i][2:]
+			continue
+		}
 
-	var added []string
-	var clean []string
-	var deleted []string
-	var ignored []string
-	var lookup []string
-	var modified []string
-	var removed []string
-	var unknown []string
+		// Options taking a value: "--rev REV" or "--rev=REV".
+		name, value := args[i], ""
+		if j := strings.IndexByte(name, '='); j >= 0 && strings.HasPrefix(name, "--") {
+			name, value = name[:j], name[j+1:]
+		}
+		if (name == "--rev" || name == "--change" || name == "-t" || name == "--terse" || name == "-T" || name == "--template") && value == "" && !strings.Contains(args[i], "=") {
+			if i+1 == len(args) {
+				return Abort("option %s requires argument\n", name)
+			}
+			i++
+			value = args[i]
+		}
 
-	for i := range args {
-		switch args[i] {
+		switch name {
 		case "-A", "--all":
 			listignored = true
 			listclean = true
@@ -286,13 +173,54 @@ func (c *StatusCommand) Run(args []string) int {
 			listclean = true
 		case "-u", "--unknown":
 			listunknown = true
-		case "-i", "--ignored ":
+		case "-i", "--ignored":
 			listignored = true
 		case "-n", "--no-status":
 			nostatus = true
+		case "-C", "--copies":
+			listcopies = true
+		case "-S", "--subrepos":
+			subrepos = true
+		case "-v", "--verbose":
+			verbose = true
+		case "--rev":
+			revs = append(revs, value)
+		case "--change":
+			change = value
+		case "-t", "--terse":
+			terse = value
+		case "-0", "--print0":
+			print0 = true
+		case "-T", "--template":
+			template = value
 		}
 	}
 
+	// Without any status selected, show everything but clean and ignored files.
+	if !(listmodified || listadded || listremoved || listdeleted || listunknown || listignored || listclean) {
+		listmodified = true
+		listadded = true
+		listremoved = true
+		listdeleted = true
+		listunknown = true
+	}
+
+	if change != "" && len(revs) > 0 {
+		return Abort("cannot specify --rev and --change at the same time\n")
+	}
+	if terse != "" && len(revs) > 0 {
+		return Abort("cannot use --terse with --rev\n")
+	}
+	if subrepos && (change != "" || len(revs) > 0) {
+		return Abort("--subrepos is only supported for the working directory\n")
+	}
+	if err := checkTerse(terse); err != nil {
+		return Abort("%s\n", err)
+	}
+	if template != "" && template != "json" {
+		return Abort("unsupported template: %s (only json is)\n", template)
+	}
+
 	wd, err := os.Getwd()
 	if err != nil {
 		return Abort("error getting current working directory: %s", err)
@@ -300,111 +228,279 @@ func (c *StatusCommand) Run(args []string) int {
 
 	repo, err := repo.Open(wd)
 	if err != nil {
-		return Abort("%s!\n", err)
+		return AbortErr(err)
+	}
+	if _, err := repo.Config(); err != nil {
+		return AbortParseError(err)
 	}
 
 	// All the previous code ^^^ to be removed completely on stage 1.
 
-	path := filepath.Join(repo.RootDir, ".hg", "dirstate")
-	f, err := os.Open(path)
+	// Only files matching the patterns given are shown.
+	files, err := match.NewFileMatcher(repo.RootDir, wd, pats)
 	if err != nil {
-		return Abort("%s!\n", err)
+		return Abort("%s\n", err)
 	}
-	defer f.Close()
 
-	finfo, err := f.Stat()
-	if err != nil {
-		return Abort("%s!\n", err)
+	// The changesets compared, wdirNode standing for the working directory.
+	var node1, node2 revlog.Node
+	var st *statusLists
+	switch {
+	case change != "":
+		node2, err = repo.Lookup(change)
+		if err != nil {
+			return Abort("%s\n", err)
+		}
+		node1, err = changesetParent(repo, node2)
+		if err != nil {
+			return Abort("%s!\n", err)
+		}
+		st, err = revStatus(repo, node1, node2, files)
+		if err != nil {
+			return Abort("%s!\n", err)
+		}
+
+	case len(revs) >= 2:
+		node1, err = repo.Lookup(revs[0])
+		if err != nil {
+			return Abort("%s\n", err)
+		}
+		node2, err = repo.Lookup(revs[len(revs)-1])
+		if err != nil {
+			return Abort("%s\n", err)
+		}
+		st, err = revStatus(repo, node1, node2, files)
+		if err != nil {
+			return Abort("%s!\n", err)
+		}
+
+	default:
+		if len(revs) == 1 {
+			node1, err = repo.Lookup(revs[0])
+			if err != nil {
+				return Abort("%s\n", err)
+			}
+		}
+		// Tersing ignored files needs all of them.
+		st, err = workingStatus(repo, files, listignored || strings.Contains(terse, "i"))
+		if err != nil {
+			return Abort("%s!\n", err)
+		}
+		node2 = wdirNode
+		if subrepos {
+			if err := addSubrepoStatus(repo, st, listignored || strings.Contains(terse, "i"), ""); err != nil {
+				return AbortErr(err)
+			}
+		}
+		if len(revs) == 0 {
+			node1 = st.parent
+		} else if node1 != st.parent {
+			st, err = workingRevStatus(repo, st, node1, files)
+			if err != nil {
+				return Abort("%s!\n", err)
+			}
+		}
 	}
 
-	// skip parents
-	readNextBytes(f, 40)
+	// Keep the files of the filesets given.
+	st.modified = filterFiles(files, st.modified, 'M', st.stats)
+	st.added = filterFiles(files, st.added, 'A', st.stats)
+	st.removed = filterFiles(files, st.removed, 'R', st.stats)
+	st.deleted = filterFiles(files, st.deleted, '!', st.stats)
+	st.unknown = filterFiles(files, st.unknown, '?', st.stats)
+	st.ignored = filterFiles(files, st.ignored, 'I', st.stats)
+	st.clean = filterFiles(files, st.clean, 'C', st.stats)
+
+	var copies map[string]string
+	if listcopies {
+		copies, err = statusCopies(repo, st, node1, node2)
+		if err != nil {
+			return Abort("%s!\n", err)
+		}
+	}
 
-	// dirstate content
-	fileTree := radix.New()
-	copyTree := radix.New()
-
-	// read filenames
-	var offset int64 = 40 // we're now right after parent2
-	for offset < finfo.Size() {
-		header := Header{}
-		data := readNextBytes(f, 17)
-		offset += 17
-
-		buffer := bytes.NewBuffer(data)
-		err = binary.Read(buffer, binary.BigEndian, &header)
+	if terse != "" {
+		terseDirs(st, terse)
+	}
+
+	// Print respective slices to show the status():
+	uipath, err := uiPathFunc(repo, wd, len(pats) > 0, "status.relative")
+	if err != nil {
+		return Abort("%s\n", err)
+	}
+	sw := newStatusWriter(os.Stdout, template == "json", nostatus, print0, uipath)
+	printSlice(sw, listmodified, "M", st.modified, copies, st.unsure)
+	printSlice(sw, listadded, "A", st.added, copies, nil)
+	printSlice(sw, listremoved, "R", st.removed, nil, nil)
+	printSlice(sw, listdeleted, "!", st.deleted, nil, nil)
+	printSlice(sw, listunknown, "?", st.unknown, nil, nil)
+	printSlice(sw, listignored, "I", st.ignored, nil, nil)
+	printSlice(sw, listclean, "C", st.clean, nil, st.unsure)
+	if !verbose {
+		cfg, err := repo.Config()
 		if err != nil {
-			log.Fatal("binary.Read failed:", err)
-			return 255
+			return Abort("%s\n", err)
+		}
+		verbose, err = cfg.Bool("commands", "status.verbose", false)
+		if err != nil {
+			return Abort("%s\n", err)
+		}
+	}
+	if verbose && node2 == wdirNode {
+		if err := printMoreStatus(sw, repo, wd); err != nil {
+			return Abort("%s\n", err)
 		}
+	}
+	if err := sw.Close(); err != nil {
+		return Abort("%s\n", err)
+	}
+
+	// Conflicts only matter to the working directory.
+	if !verbose && node2 == wdirNode {
+		warnUnresolved(repo)
+	}
+
+	return 0
+}
 
-		name := readNextBytes(f, int(header.Namelen))
-		offset += int64(header.Namelen)
+// printMoreStatus describes the operation left unfinished in the working directory
+// and its merge conflicts, if any.
+//
+// Mechanical translation of cmdutil.morestatus.
+func printMoreStatus(sw *statusWriter, r *repo.Repo, wd string) error {
+	state, err := r.UnfinishedState()
+	if err != nil {
+		return err
+	}
+	ms, err := r.MergeState()
+	if err != nil {
+		return err
+	}
 
-		filename := string(name)
+	if state != nil {
+		if sw.json {
+			sw.writeItem(map[string]string{"itemtype": "morestatus", "unfinished": state.Name, "unfinishedmsg": state.Hint})
+		}
+		sw.comment(fmt.Sprintf("The repository is in an unfinished *%s* state.", state.Name))
+	}
 
-		// If the name contains a null character, it is split into two strings,
-		// with the second being the copy source for move and copy operations.
-		for i := 0; i < int(header.Namelen); i++ {
-			if name[i] == 0 {
-				copysource := string(name[i+1 : header.Namelen])
-				filename = string(name[0:i])
-				copyTree.Insert(filename, copysource)
-				break
+	if ms.Active() {
+		if unresolved := ms.Unresolved(); len(unresolved) > 0 {
+			cwd, err := match.CanonPath(r.RootDir, wd, wd)
+			if err != nil {
+				return err
+			}
+			var list []string
+			for _, f := range unresolved {
+				list = append(list, "    "+match.PathTo(cwd, f))
 			}
+			sw.comment("Unresolved merge conflicts:\n\n" + strings.Join(list, "\n") + "\n\nTo mark files as resolved:  hg resolve --mark FILE")
+		} else {
+			sw.comment("No unresolved merge conflicts.")
 		}
+	}
 
-		fileTree.Insert(filename, DirStateFileInfo{
-			state: FileState(header.State),
-			mode:  header.Mode,
-			size:  int64(header.Size),
-			mtime: header.Mtime,
-		})
+	if state != nil {
+		sw.comment(state.Hint)
 	}
+	return nil
+}
 
-	// step 0: read .hgignore
-	ignoreMatchers, err := parseHgIgnore(filepath.Join(repo.RootDir, ".hgignore"))
+// warnUnresolved tells about the conflicts left unresolved by an unfinished merge.
+func warnUnresolved(r *repo.Repo) {
+	ms, err := r.MergeState()
 	if err != nil {
-		return Abort("%s!\n", err)
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		return
+	}
+	if n := len(ms.Unresolved()); n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d unresolved merge conflicts (see 'hgo resolve --list')\n", n)
 	}
+}
 
-	// step 1: find all explicit files
-	filesFound := radix.New()
+// workingStatus compares the working directory with the dirstate,
+// looking at the files the matcher may select.
+func workingStatus(repo *repo.Repo, files *match.FileMatcher, listignored bool) (*statusLists, error) {
+	var added []string
+	var clean []string
+	var deleted []string
+	var ignored []string
+	var lookup []string
+	var modified []string
+	var removed []string
+	var unknown []string
+	unsure := make(map[string]string)
 
-	// Prepare traverse function...
-	var fsWalkFn filepath.WalkFunc = func(path string, info os.FileInfo, err error) error {
-		filename := strings.TrimPrefix(path, repo.RootDir+"/")
+	// The mtime of the dirstate file tells when the stat data it records was taken.
+	// Remember it too to avoid overwriting someone else's changes.
+	dsInfo, _ := os.Stat(repo.Join("dirstate"))
+	ds, err := repo.DirState()
+	if err != nil {
+		return nil, err
+	}
 
-		// Skip .hg and all its files and subdirs unconditionnaly.
-		if filename == ".hg" && info.IsDir() {
-			return filepath.SkipDir
-		}
+	// dirstate content
+	fileTree := ds.Tree()
 
-		if !info.IsDir() {
-			filesFound.Insert(filename, info)
+	// step 0: read .hgignore and the ignore files from the config
+	ignoreMatcher, err := repo.Ignore()
+	if err != nil {
+		return nil, err
+	}
+	ignore := newIgnorer(ignoreMatcher)
+
+	// step 1: find all files in the working directory.
+	// Only tracked files need stat data, unknown ones are reported by name.
+	// Ignored directories are not walked unless ignored files are listed,
+	// tracked files inside them are found in step 2.
+	opts := walkOptions{
+		needStat: func(path string) bool {
+			_, tracked := fileTree.Get(path)
+			return tracked
+		},
+	}
+	if !listignored {
+		opts.skipDir = ignore.dir
+	}
+	opts.roots = files.Roots()
+	filesFound, walkErrs := walkWorkingDir(repo.RootDir, opts)
+	for _, werr := range walkErrs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", werr.path, werr.err)
+	}
+	for _, root := range opts.roots {
+		if _, err := os.Lstat(filepath.Join(repo.RootDir, filepath.FromSlash(root))); os.IsNotExist(err) && !tracked(fileTree, root) {
+			fmt.Fprintf(os.Stderr, "%s: No such file or directory\n", root)
 		}
-		return nil
 	}
-	// ... and walk.
-	filepath.Walk(repo.RootDir, fsWalkFn)
 
-	// Traverse the dirstate fileTree too
+	// step 2: stat tracked files the walk didn't find: they are either missing
+	// or live somewhere the walk doesn't go.
 	var dirstateWalkFn radix.WalkFn = func(k string, raw interface{}) bool {
-		info, err := os.Stat(filepath.Join(repo.RootDir, k))
+		if !files.MayMatch(k) {
+			return false
+		}
+		if _, found := filesFound.Get(k); found {
+			return false
+		}
+		info, err := os.Lstat(filepath.Join(repo.RootDir, k))
 		if err != nil {
 			filesFound.Insert(k, nil)
+		} else {
+			filesFound.Insert(k, info)
 		}
-		filesFound.Insert(k, info)
 		return false
 	}
 	fileTree.Walk(dirstateWalkFn)
 
-	// Walk all found files and put the into respective tree:
+	// step 3: walk all found files and put them into respective slices:
 	var tossWalkFn radix.WalkFn = func(k string, raw interface{}) bool {
+		if !files.MayMatch(k) {
+			return false
+		}
 		dirstateraw, found := fileTree.Get(k)
 
 		if !found {
-			if shouldIgnore(k, ignoreMatchers) {
+			if ignore.file(k) {
 				ignored = append(ignored, k)
 			} else {
 				unknown = append(unknown, k)
@@ -412,37 +508,42 @@ func (c *StatusCommand) Run(args []string) int {
 			return false
 		}
 
-		dirstatefileinfo := dirstateraw.(DirStateFileInfo)
+		dirstatefileinfo := dirstateraw.(*dirstate.Entry)
 
-		if raw == nil && (dirstatefileinfo.state == Normal || dirstatefileinfo.state == Merged || dirstatefileinfo.state == Added) {
+		if raw == nil && (dirstatefileinfo.State == dirstate.Normal || dirstatefileinfo.State == dirstate.Merged || dirstatefileinfo.State == dirstate.Added) {
 			deleted = append(deleted, k)
 			return false
 		}
 
-		stat := raw.(os.FileInfo)
-		_, foundCopy := copyTree.Get(k)
-		if foundCopy {
-			added = append(added, k)
-			return false
-		}
+		// Removed files may be missing.
+		stat, _ := raw.(os.FileInfo)
 
-		switch dirstatefileinfo.state {
-		case Normal:
-			if dirstatefileinfo.size > 0 && (dirstatefileinfo.size != stat.Size() || dirstatefileinfo.mode&0x0fff != uint32(stat.Mode())) || dirstatefileinfo.size == -2 || foundCopy {
+		switch dirstatefileinfo.State {
+		case dirstate.Normal:
+			// Sizes are recorded modulo 2^31.
+			sizeChanged := int64(dirstatefileinfo.Size) != stat.Size()&0x7fffffff
+			if dirstatefileinfo.Size >= 0 && sizeChanged && stat.Mode()&os.ModeSymlink != 0 {
+				// The size of a symlink isn't always the length of its target (issue6456).
+				lookup = append(lookup, k)
+				unsure[k] = "symlink size changed"
+			} else if dirstatefileinfo.Size >= 0 && (sizeChanged || modeChanged(dirstatefileinfo.Mode, stat)) || dirstatefileinfo.Size == dirstate.SizeFromP2 ||
+				dirstatefileinfo.CopySource != "" {
+				// A copy recorded over a tracked file changes it even if its content doesn't.
 				modified = append(modified, k)
-			} else if int64(dirstatefileinfo.mtime) != stat.ModTime().UnixNano()/int64(time.Second) {
+			} else if reason := lookupReason(dirstatefileinfo, stat, dsInfo); reason != "" {
 				lookup = append(lookup, k)
+				unsure[k] = reason
 			} else {
 				clean = append(clean, k)
 			}
 
-		case Merged:
+		case dirstate.Merged:
 			modified = append(modified, k)
 
-		case Added:
+		case dirstate.Added:
 			added = append(added, k)
 
-		case Removed:
+		case dirstate.Removed:
 			removed = append(removed, k)
 
 		}
@@ -451,16 +552,574 @@ func (c *StatusCommand) Run(args []string) int {
 	}
 	filesFound.Walk(tossWalkFn)
 
-	// Print respective slices to show the status():
-	printSlice(listmodified, "M", modified, nostatus)
-	printSlice(listadded, "A", added, nostatus)
-	printSlice(listremoved, "R", removed, nostatus)
-	printSlice(listdeleted, "!", deleted, nostatus)
-	printSlice(listunknown, "?", unknown, nostatus)
-	printSlice(listignored, "I", ignored, nostatus)
-	printSlice(listclean, "C", clean, nostatus)
+	// step 4: compare files with unreliable stat data to the first parent.
+	if len(lookup) > 0 {
+		lookupModified, lookupClean, err := checkLookup(repo, revlog.Node(ds.Parents[0]), lookup, filesFound)
+		if err != nil {
+			return nil, err
+		}
+		if len(lookupModified) > 0 {
+			modified = append(modified, lookupModified...)
+			sort.Strings(modified)
+		}
+		if len(lookupClean) > 0 {
+			clean = append(clean, lookupClean...)
+			sort.Strings(clean)
 
-	return 0
+			if err := fixupDirState(repo, ds, dsInfo, fileTree, filesFound, lookupClean); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &statusLists{
+		modified:  modified,
+		added:     added,
+		removed:   removed,
+		deleted:   deleted,
+		unknown:   unknown,
+		ignored:   ignored,
+		clean:     clean,
+		parent:    revlog.Node(ds.Parents[0]),
+		stats:     filesFound,
+		dirCopies: dirstateCopies(ds),
+		unsure:    unsure,
+	}, nil
+}
+
+// modeChanged reports whether the exec bit or the type of a file, regular file
+// or symlink, differ from the mode recorded in the dirstate. Other permission
+// bits don't matter.
+func modeChanged(mode uint32, info os.FileInfo) bool {
+	return (mode^posixMode(info))&(0170000|0100) != 0
+}
+
+// lookupReason tells why the stat data of a file in normal state, with the size
+// and mode recorded in the dirstate, does not show whether the file changed.
+// dsInfo is the stat data of the dirstate file, whose mtime is when it was written.
+// It returns "" when the file is clean.
+func lookupReason(e *dirstate.Entry, stat, dsInfo os.FileInfo) string {
+	switch {
+	case e.Mtime == dirstate.MtimeUnset:
+		return "mtime unset"
+	case !e.MtimeEqual(stat.ModTime()):
+		return "mtime changed"
+	case dsInfo != nil && e.MtimeAmbiguous(dsInfo.ModTime()):
+		// The file may have changed after the dirstate was written, within the same tick.
+		return "mtime ambiguous"
+	}
+	return ""
+}
+
+// addSubrepoStatus adds the working directory status of the hg subrepositories
+// of r to st, recursively, with their paths prefixed by the subrepository path.
+// Like Mercurial, a subrepository is compared to the revision recorded for it
+// in .hgsubstate, or to the parent of its working directory when there is none.
+// prefix is the path of r in the top repository, "" for the top repository itself.
+// Subrepositories that are not checked out are left out.
+func addSubrepoStatus(r *repo.Repo, st *statusLists, listignored bool, prefix string) error {
+	subs, err := r.Subrepos()
+	if err != nil {
+		return err
+	}
+
+	if st.subCopies == nil {
+		st.subCopies = make(map[string]string)
+	}
+	for _, sub := range subs {
+		if sub.Kind != "hg" {
+			fmt.Fprintf(os.Stderr, "skipping %s subrepository %s\n", sub.Kind, prefix+sub.Path)
+			continue
+		}
+		subroot := filepath.Join(r.RootDir, filepath.FromSlash(sub.Path))
+		if info, err := os.Stat(filepath.Join(subroot, ".hg")); err != nil || !info.IsDir() {
+			continue
+		}
+		sr, err := repo.Open(subroot)
+		if err != nil {
+			return err
+		}
+		files, err := match.NewFileMatcher(sr.RootDir, sr.RootDir, nil)
+		if err != nil {
+			return err
+		}
+		subst, err := workingStatus(sr, files, listignored)
+		if err != nil {
+			return err
+		}
+		base := subst.parent
+		if sub.Revision != "" {
+			base, err = sr.Lookup(sub.Revision)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: error \"%s\" in subrepository \"%s\"\n", err, prefix+sub.Path)
+				continue
+			}
+		}
+		if base != subst.parent {
+			subst, err = workingRevStatus(sr, subst, base, files)
+			if err != nil {
+				return err
+			}
+		}
+		copies, err := statusCopies(sr, subst, base, wdirNode)
+		if err != nil {
+			return err
+		}
+		subprefix := prefix + sub.Path + "/"
+		if err := addSubrepoStatus(sr, subst, listignored, subprefix); err != nil {
+			return err
+		}
+
+		lists := []*[]string{&st.modified, &st.added, &st.removed, &st.deleted, &st.unknown, &st.ignored, &st.clean}
+		sublists := [][]string{subst.modified, subst.added, subst.removed, subst.deleted, subst.unknown, subst.ignored, subst.clean}
+		for i, l := range sublists {
+			for _, f := range l {
+				*lists[i] = append(*lists[i], sub.Path+"/"+f)
+			}
+		}
+		subst.stats.Walk(func(f string, raw interface{}) bool {
+			st.stats.Insert(sub.Path+"/"+f, raw)
+			return false
+		})
+		for f, reason := range subst.unsure {
+			st.unsure[sub.Path+"/"+f] = reason
+		}
+		for dst, src := range copies {
+			st.subCopies[sub.Path+"/"+dst] = sub.Path + "/" + src
+		}
+		for dst, src := range subst.subCopies {
+			st.subCopies[sub.Path+"/"+dst] = sub.Path + "/" + src
+		}
+	}
+
+	st.sort()
+	return nil
+}
+
+// dirstateCopies returns the copy sources recorded for files still tracked.
+func dirstateCopies(ds *dirstate.DirState) map[string]string {
+	copies := make(map[string]string)
+	for _, e := range ds.Entries {
+		if e.CopySource != "" && e.State != dirstate.Removed {
+			copies[e.Name] = e.CopySource
+		}
+	}
+	return copies
+}
+
+// wdirNode stands for the unknown node of files changed in the working directory.
+var wdirNode = revlog.Node{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// changesetParent returns the first parent of the changeset node.
+func changesetParent(r *repo.Repo, node revlog.Node) (revlog.Node, error) {
+	cl, err := r.Changelog()
+	if err != nil {
+		return revlog.NullNode, err
+	}
+	rev, err := cl.Rev(node)
+	if err != nil {
+		return revlog.NullNode, err
+	}
+	p1, _, err := cl.Parents(rev)
+	if err != nil {
+		return revlog.NullNode, err
+	}
+	return cl.Node(p1)
+}
+
+// revStatus compares the manifests of two changesets.
+// Files are modified when their nodes or flags differ, contents are not compared.
+func revStatus(r *repo.Repo, node1, node2 revlog.Node, files *match.FileMatcher) (*statusLists, error) {
+	mf1, err := r.Manifest(node1)
+	if err != nil {
+		return nil, err
+	}
+	mf2, err := r.Manifest(node2)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &statusLists{}
+	for f, a := range mf1 {
+		if !files.MayMatch(f) {
+			continue
+		}
+		if b, ok := mf2[f]; !ok {
+			st.removed = append(st.removed, f)
+		} else if a != b {
+			st.modified = append(st.modified, f)
+		} else {
+			st.clean = append(st.clean, f)
+		}
+	}
+	for f := range mf2 {
+		if _, ok := mf1[f]; !ok && files.MayMatch(f) {
+			st.added = append(st.added, f)
+		}
+	}
+
+	st.sort()
+	return st, nil
+}
+
+// workingRevStatus turns ws, the status of the working directory against its parent,
+// into the status of the working directory against the changeset node.
+//
+// Mechanical translation of basectx._buildstatus: the manifest of the working directory
+// is the one of its parent with the changes of ws applied, where changed files get wdirNode.
+func workingRevStatus(r *repo.Repo, ws *statusLists, node revlog.Node, files *match.FileMatcher) (*statusLists, error) {
+	mf1, err := r.Manifest(node)
+	if err != nil {
+		return nil, err
+	}
+	pmf, err := r.Manifest(ws.parent)
+	if err != nil {
+		return nil, err
+	}
+
+	mf2 := make(manifest.Manifest, len(pmf))
+	for f, mf := range pmf {
+		mf2[f] = mf
+	}
+	for _, f := range ws.added {
+		mf2[f] = manifest.File{Node: wdirNode}
+	}
+	for _, f := range ws.modified {
+		mf2[f] = manifest.File{Node: wdirNode}
+	}
+	deleted := make(map[string]bool, len(ws.deleted))
+	for _, f := range ws.deleted {
+		deleted[f] = true
+		delete(mf2, f)
+	}
+	for _, f := range ws.removed {
+		delete(mf2, f)
+	}
+
+	st := &statusLists{
+		deleted:   ws.deleted,
+		parent:    ws.parent,
+		stats:     ws.stats,
+		dirCopies: ws.dirCopies,
+		unsure:    ws.unsure,
+	}
+	for f, a := range mf1 {
+		if deleted[f] || !files.MayMatch(f) {
+			continue
+		}
+		b, ok := mf2[f]
+		switch {
+		case !ok:
+			st.removed = append(st.removed, f)
+		case b.Node != wdirNode && a != b:
+			st.modified = append(st.modified, f)
+		case b.Node != wdirNode:
+			st.clean = append(st.clean, f)
+		default:
+			raw, _ := ws.stats.Get(f)
+			changed, err := fileChanged(r, f, a, raw.(os.FileInfo))
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				st.modified = append(st.modified, f)
+			} else {
+				st.clean = append(st.clean, f)
+			}
+		}
+	}
+	for f := range mf2 {
+		if _, ok := mf1[f]; !ok && !deleted[f] && files.MayMatch(f) {
+			st.added = append(st.added, f)
+		}
+	}
+
+	// Files reported as removed are not unknown or ignored at the same time.
+	for _, f := range ws.unknown {
+		if _, ok := mf1[f]; !ok {
+			st.unknown = append(st.unknown, f)
+		}
+	}
+	for _, f := range ws.ignored {
+		if _, ok := mf1[f]; !ok {
+			st.ignored = append(st.ignored, f)
+		}
+	}
+
+	st.sort()
+	return st, nil
+}
+
+// statusCopies returns the copy sources of the added and modified files of st,
+// a comparison of the changesets node1 and node2, node2 being wdirNode for the working directory.
+// Sources are files of node1.
+//
+// Copies of the working directory come from the dirstate, committed ones
+// from the copy metadata of filelogs.
+func statusCopies(r *repo.Repo, st *statusLists, node1, node2 revlog.Node) (map[string]string, error) {
+	mf1, err := r.Manifest(node1)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make(map[string]string)
+	committed := node2
+	if node2 == wdirNode {
+		// Copies in subrepositories were already checked against their own base.
+		for dst, src := range st.subCopies {
+			copies[dst] = src
+		}
+		for dst, src := range st.dirCopies {
+			if _, ok := mf1[src]; ok && src != dst {
+				copies[dst] = src
+			}
+		}
+		// Files committed since node1 may have been copied on the way.
+		committed = st.parent
+	}
+	if committed == node1 {
+		return copies, nil
+	}
+
+	mf2, err := r.Manifest(committed)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range append(st.added, st.modified...) {
+		if _, ok := copies[f]; ok {
+			continue
+		}
+		if _, ok := mf1[f]; ok {
+			continue
+		}
+		mf, ok := mf2[f]
+		if !ok {
+			continue
+		}
+		src, err := traceCopy(r, f, mf.Node, mf1)
+		if err != nil {
+			return nil, err
+		}
+		if src != "" && src != f {
+			copies[f] = src
+		}
+	}
+
+	return copies, nil
+}
+
+// traceCopy follows the first parents and copies of revision node of path
+// back to a file revision of mf, returning its path or "" if there is none.
+//
+// Simplified translation of copies._tracefile.
+func traceCopy(r *repo.Repo, path string, node revlog.Node, mf manifest.Manifest) (string, error) {
+	for node != revlog.NullNode {
+		if mfile, ok := mf[path]; ok && mfile.Node == node {
+			return path, nil
+		}
+
+		fl, err := r.Filelog(path)
+		if err != nil {
+			return "", err
+		}
+		rev, err := fl.Rev(node)
+		if err != nil {
+			fl.Close()
+			return "", err
+		}
+		src, srcNode, renamed, err := fl.Renamed(rev)
+		if err != nil {
+			fl.Close()
+			return "", err
+		}
+		if renamed {
+			path, node = src, srcNode
+		} else {
+			p1, _, err := fl.Parents(rev)
+			if err != nil {
+				fl.Close()
+				return "", err
+			}
+			if node, err = fl.Node(p1); err != nil {
+				fl.Close()
+				return "", err
+			}
+		}
+		fl.Close()
+	}
+	return "", nil
+}
+
+func (st *statusLists) sort() {
+	for _, l := range [][]string{st.modified, st.added, st.removed, st.deleted, st.unknown, st.ignored, st.clean} {
+		sort.Strings(l)
+	}
+}
+
+// tracked reports whether the dirstate has path or files below it.
+func tracked(fileTree *radix.Tree, path string) bool {
+	if _, ok := fileTree.Get(path); ok {
+		return true
+	}
+	found := false
+	fileTree.WalkPrefix(path+"/", func(k string, raw interface{}) bool {
+		found = true
+		return true
+	})
+	return found
+}
+
+// filterFiles returns the files with the given status code that the matcher selects.
+// Only filesets need the status to tell.
+func filterFiles(m *match.FileMatcher, files []string, code byte, stats *radix.Tree) []string {
+	if m.Always() {
+		return files
+	}
+
+	var res []string
+	for _, f := range files {
+		fi := match.FileInfo{Status: code}
+		if stats != nil {
+			if raw, ok := stats.Get(f); ok && raw != nil {
+				fi.Stat = raw.(os.FileInfo)
+			}
+		}
+		if m.MatchFile(f, fi) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// checkLookup compares the content and flags of files against the manifest of changeset p1.
+func checkLookup(r *repo.Repo, p1 revlog.Node, files []string, stats *radix.Tree) (modified, clean []string, err error) {
+	m, err := r.Manifest(p1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range files {
+		mf, ok := m[f]
+		if !ok {
+			modified = append(modified, f)
+			continue
+		}
+
+		raw, _ := stats.Get(f)
+		changed, err := fileChanged(r, f, mf, raw.(os.FileInfo))
+		if err != nil {
+			return nil, nil, err
+		}
+		if changed {
+			modified = append(modified, f)
+		} else {
+			clean = append(clean, f)
+		}
+	}
+
+	return modified, clean, nil
+}
+
+// fileChanged reports whether the working copy of path differs from its manifest revision mf.
+func fileChanged(r *repo.Repo, path string, mf manifest.File, info os.FileInfo) (bool, error) {
+	isLink := info.Mode()&os.ModeSymlink != 0
+	isExec := !isLink && info.Mode()&0100 != 0
+	if isLink != (mf.Flag == manifest.FlagSymlink) || isExec != (mf.Flag == manifest.FlagExec) {
+		return true, nil
+	}
+
+	// Symlinks are stored as their target.
+	var data []byte
+	wpath := filepath.Join(r.RootDir, path)
+	if isLink {
+		target, err := os.Readlink(wpath)
+		if err != nil {
+			return false, err
+		}
+		data = []byte(target)
+	} else {
+		content, err := ioutil.ReadFile(wpath)
+		if err != nil {
+			return false, err
+		}
+		data = content
+	}
+
+	fl, err := r.Filelog(path)
+	if err != nil {
+		return false, err
+	}
+	defer fl.Close()
+
+	rev, err := fl.Rev(mf.Node)
+	if err != nil {
+		return false, err
+	}
+	return fl.Cmp(rev, data)
+}
+
+// fixupDirState records the stat data of files found clean by content,
+// so that the next status doesn't need to read them again.
+// Like Mercurial, it gives up silently if the working directory is locked.
+func fixupDirState(r *repo.Repo, ds *dirstate.DirState, before os.FileInfo, fileTree, stats *radix.Tree, clean []string) error {
+	// Writing would drop dirstate-v2 node data that the entries don't hold.
+	if !ds.Lossless() {
+		return nil
+	}
+
+	lock, err := r.TryWLock()
+	if err != nil {
+		return nil
+	}
+	defer lock.Release()
+
+	// Don't overwrite a dirstate written since we've read it.
+	after, err := os.Stat(r.Join("dirstate"))
+	if (before == nil) != (err != nil) {
+		return nil
+	}
+	if before != nil && (before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime())) {
+		return nil
+	}
+
+	now := time.Now()
+	changed := false
+	for _, f := range clean {
+		raw, _ := fileTree.Get(f)
+		e := raw.(*dirstate.Entry)
+		raw, _ = stats.Get(f)
+		info := raw.(os.FileInfo)
+		if e.State != dirstate.Normal {
+			continue
+		}
+
+		// A file written in the current tick may change again without its mtime moving.
+		mtime := info.ModTime()
+		recorded := dirstate.Entry{Mtime: int32(mtime.Unix() & 0x7fffffff), MtimeNsec: int32(mtime.Nanosecond())}
+		if recorded.MtimeAmbiguous(now) {
+			continue
+		}
+
+		e.Mode = posixMode(info)
+		e.Size = int32(info.Size() & 0x7fffffff)
+		e.Mtime = recorded.Mtime
+		e.MtimeNsec = recorded.MtimeNsec
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return r.WriteDirState(ds)
+}
+
+// posixMode converts a file mode to the st_mode value Mercurial records in the dirstate.
+func posixMode(info os.FileInfo) uint32 {
+	mode := uint32(info.Mode().Perm())
+	if info.Mode()&os.ModeSymlink != 0 {
+		return mode | 0120000
+	}
+	return mode | 0100000
 }
 
 func (c *StatusCommand) Synopsis() string {
@@ -469,8 +1128,40 @@ func (c *StatusCommand) Synopsis() string {
 
 func (c *StatusCommand) Help() string {
 	helpText := `
+Usage: hgo status [OPTION]... [FILE]...
+
 Show status of files in the repository.
 If names are given, only files that match are shown.
+Names are paths relative to the current directory, or patterns
+such as glob:, re:, path:, rootfilesin:, listfile: and set:.
+
+Paths are shown relative to the current directory when names are given,
+relative to the repository root otherwise. Setting ui.relative-paths
+or commands.status.relative to a boolean overrides that.
+
+With one --rev REV, the working directory is compared to REV instead of
+its parent. With two, the revisions are compared to each other.
+--change REV shows the files changed in REV.
+
+-0 (--print0) ends file names with NUL instead of newline, and -T json
+(--template json) prints a JSON list of objects with the keys "path",
+"status", "source" (the copy source, with --copies) and "unsure" (why
+the file was compared by content: "mtime changed", "mtime unset",
+"mtime ambiguous" or "symlink size changed").
+
+Directories holding a .hg directory are nested repositories and are left
+out. With -S (--subrepos), the hg subrepositories listed in .hgsub are
+shown too, their files prefixed with the subrepository path and compared
+to the revision recorded in .hgsubstate.
+
+With -v (--verbose), or when commands.status.verbose is set, status
+also tells about an unfinished rebase, histedit, unshelve, graft, update
+or merge, how to continue or abort it, and the merge conflicts left.
+
+--terse STATUS collapses a directory into a single "dir/" line when all
+its files have the same status and that status is one of the letters of
+STATUS: m, a, r, d, u, i, c (modified, added, removed, deleted, unknown,
+ignored, clean).
 
 The codes used to show the status of files are:
 
@@ -0,0 +1,935 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	radix "github.com/armon/go-radix"
+	"github.com/sashka/hgo/dirstate"
+	"github.com/sashka/hgo/manifest"
+	"github.com/sashka/hgo/match"
+	"github.com/sashka/hgo/repo"
+	"github.com/sashka/hgo/revlog"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// revision is a revision of a test revlog.
+type revision struct {
+	text   string
+	p1, p2 revlog.Rev
+}
+
+// writeRevlog writes an inline revlog of uncompressed full texts and returns the nodes of its revisions.
+func writeRevlog(t *testing.T, path string, revs ...revision) []revlog.Node {
+	t.Helper()
+	var buf bytes.Buffer
+	var nodes []revlog.Node
+	var offset int
+	node := func(rev revlog.Rev) revlog.Node {
+		if rev == revlog.NullRev {
+			return revlog.NullNode
+		}
+		return nodes[rev]
+	}
+	for i, rev := range revs {
+		chunk := "u" + rev.text
+		n := revlog.Hash([]byte(rev.text), node(rev.p1), node(rev.p2))
+		entry := make([]byte, 64)
+		binary.BigEndian.PutUint64(entry[0:8], uint64(offset)<<16)
+		binary.BigEndian.PutUint32(entry[8:12], uint32(len(chunk)))
+		binary.BigEndian.PutUint32(entry[12:16], uint32(len(rev.text)))
+		binary.BigEndian.PutUint32(entry[16:20], uint32(i))
+		binary.BigEndian.PutUint32(entry[20:24], uint32(i))
+		binary.BigEndian.PutUint32(entry[24:28], uint32(int32(rev.p1)))
+		binary.BigEndian.PutUint32(entry[28:32], uint32(int32(rev.p2)))
+		copy(entry[32:52], n[:])
+		if i == 0 {
+			// Version 1, inline data.
+			binary.BigEndian.PutUint32(entry[0:4], 1|1<<16)
+		}
+		buf.Write(entry)
+		buf.WriteString(chunk)
+		nodes = append(nodes, n)
+		offset += len(chunk)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+// manifestText returns the text of a manifest revision without flags.
+func manifestText(files map[string]revlog.Node) string {
+	var lines []string
+	for f, node := range files {
+		lines = append(lines, f+"\x00"+node.String()+"\n")
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// copyText returns the text of a filelog revision copied from source at node.
+func copyText(source string, node revlog.Node, data string) string {
+	return "\x01\ncopy: " + source + "\ncopyrev: " + node.String() + "\n\x01\n" + data
+}
+
+// statusFixture is a repository with three changesets:
+//
+//	0: add a.txt, b.txt and d.txt
+//	1: modify a.txt, rename b.txt to e.txt
+//	2: modify e.txt, copy d.txt to f.txt
+//
+// Its working directory, whose parent is 2, has a.txt modified, g.txt added
+// as a copy of a.txt and h.txt unknown.
+type statusFixture struct {
+	repo  *repo.Repo
+	files *match.FileMatcher
+	nodes []revlog.Node
+}
+
+func newStatusFixture(t *testing.T) *statusFixture {
+	t.Helper()
+	root, err := ioutil.TempDir("", "status-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(root, ".hg", "store")
+	writeFiles(t, root, map[string]string{".hg/requires": "revlogv1\nstore\n"})
+
+	a := writeRevlog(t, filepath.Join(store, "data", "a.txt.i"),
+		revision{"a\n", -1, -1},
+		revision{"a2\n", 0, -1},
+	)
+	b := writeRevlog(t, filepath.Join(store, "data", "b.txt.i"),
+		revision{"b\n", -1, -1},
+	)
+	d := writeRevlog(t, filepath.Join(store, "data", "d.txt.i"),
+		revision{"d\n", -1, -1},
+	)
+	e := writeRevlog(t, filepath.Join(store, "data", "e.txt.i"),
+		revision{copyText("b.txt", b[0], "b\n"), -1, -1},
+		revision{"b2\n", 0, -1},
+	)
+	f := writeRevlog(t, filepath.Join(store, "data", "f.txt.i"),
+		revision{copyText("d.txt", d[0], "d\n"), -1, -1},
+	)
+	mnodes := writeRevlog(t, filepath.Join(store, "00manifest.i"),
+		revision{manifestText(map[string]revlog.Node{"a.txt": a[0], "b.txt": b[0], "d.txt": d[0]}), -1, -1},
+		revision{manifestText(map[string]revlog.Node{"a.txt": a[1], "d.txt": d[0], "e.txt": e[0]}), 0, -1},
+		revision{manifestText(map[string]revlog.Node{"a.txt": a[1], "d.txt": d[0], "e.txt": e[1], "f.txt": f[0]}), 1, -1},
+	)
+	nodes := writeRevlog(t, filepath.Join(store, "00changelog.i"),
+		revision{mnodes[0].String() + "\ntest\n0 0\na.txt\nb.txt\nd.txt\n\nadd", -1, -1},
+		revision{mnodes[1].String() + "\ntest\n0 0\na.txt\nb.txt\ne.txt\n\nrename", 0, -1},
+		revision{mnodes[2].String() + "\ntest\n0 0\ne.txt\nf.txt\n\ncopy", 1, -1},
+	)
+
+	// The working directory: files of changeset 2 recorded clean in the dirstate, then changed.
+	clean := map[string]string{"a.txt": "a2\n", "d.txt": "d\n", "e.txt": "b2\n", "f.txt": "d\n"}
+	writeFiles(t, root, clean)
+	ds := &dirstate.DirState{Parents: [2]dirstate.Node{dirstate.Node(nodes[2])}}
+	mtime := time.Unix(1500000000, 0)
+	for name := range clean {
+		path := filepath.Join(root, name)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds.Entries = append(ds.Entries, &dirstate.Entry{
+			Name:  name,
+			State: dirstate.Normal,
+			Mode:  posixMode(info),
+			Size:  int32(info.Size()),
+			Mtime: int32(mtime.Unix()),
+		})
+	}
+	writeFiles(t, root, map[string]string{"a.txt": "a3 changed\n", "g.txt": "a2\n", "h.txt": "h\n"})
+	ds.Entries = append(ds.Entries, &dirstate.Entry{
+		Name:       "g.txt",
+		State:      dirstate.Added,
+		Size:       dirstate.SizeNonNormal,
+		Mtime:      dirstate.MtimeUnset,
+		CopySource: "a.txt",
+	})
+	if err := dirstate.WriteFile(filepath.Join(root, ".hg", "dirstate"), ds); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := repo.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := match.NewFileMatcher(r.RootDir, r.RootDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &statusFixture{repo: r, files: files, nodes: nodes}
+}
+
+func (fx *statusFixture) close() {
+	os.RemoveAll(fx.repo.RootDir)
+}
+
+// byStatus maps the status letters of st to their non-empty file lists.
+func byStatus(st *statusLists) map[string][]string {
+	m := make(map[string][]string)
+	lists := map[string][]string{"M": st.modified, "A": st.added, "R": st.removed, "!": st.deleted, "?": st.unknown, "I": st.ignored, "C": st.clean}
+	for code, l := range lists {
+		if len(l) > 0 {
+			m[code] = l
+		}
+	}
+	return m
+}
+
+func TestRevStatus(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	tests := []struct {
+		rev1, rev2 int
+		want       map[string][]string
+	}{
+		{0, 1, map[string][]string{"M": {"a.txt"}, "A": {"e.txt"}, "R": {"b.txt"}, "C": {"d.txt"}}},
+		{0, 2, map[string][]string{"M": {"a.txt"}, "A": {"e.txt", "f.txt"}, "R": {"b.txt"}, "C": {"d.txt"}}},
+		{1, 2, map[string][]string{"M": {"e.txt"}, "A": {"f.txt"}, "C": {"a.txt", "d.txt"}}},
+		{2, 0, map[string][]string{"M": {"a.txt"}, "A": {"b.txt"}, "R": {"e.txt", "f.txt"}, "C": {"d.txt"}}},
+	}
+
+	for _, tt := range tests {
+		st, err := revStatus(fx.repo, fx.nodes[tt.rev1], fx.nodes[tt.rev2], fx.files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := byStatus(st); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("revStatus(%d, %d) = %v, want %v", tt.rev1, tt.rev2, got, tt.want)
+		}
+	}
+
+	// The null revision has no files.
+	st, err := revStatus(fx.repo, revlog.NullNode, fx.nodes[0], fx.files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := byStatus(st), map[string][]string{"A": {"a.txt", "b.txt", "d.txt"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("revStatus(null, 0) = %v, want %v", got, want)
+	}
+}
+
+func TestWorkingRevStatus(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	ws, err := workingStatus(fx.repo, fx.files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.parent != fx.nodes[2] {
+		t.Fatalf("parent = %s, want %s", ws.parent, fx.nodes[2])
+	}
+	want := map[string][]string{"M": {"a.txt"}, "A": {"g.txt"}, "?": {"h.txt"}, "C": {"d.txt", "e.txt", "f.txt"}}
+	if got := byStatus(ws); !reflect.DeepEqual(got, want) {
+		t.Fatalf("workingStatus = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		rev  int
+		want map[string][]string
+	}{
+		{0, map[string][]string{"M": {"a.txt"}, "A": {"e.txt", "f.txt", "g.txt"}, "R": {"b.txt"}, "?": {"h.txt"}, "C": {"d.txt"}}},
+		{1, map[string][]string{"M": {"a.txt", "e.txt"}, "A": {"f.txt", "g.txt"}, "?": {"h.txt"}, "C": {"d.txt"}}},
+	}
+	for _, tt := range tests {
+		st, err := workingRevStatus(fx.repo, ws, fx.nodes[tt.rev], fx.files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := byStatus(st); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("workingRevStatus(%d) = %v, want %v", tt.rev, got, tt.want)
+		}
+	}
+
+	// Against changeset 0, a.txt is compared by content: a.txt of changeset 0
+	// and the working copy are both different from the parent.
+	writeFiles(t, fx.repo.RootDir, map[string]string{"a.txt": "a\n"})
+	ws, err = workingStatus(fx.repo, fx.files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := workingRevStatus(fx.repo, ws, fx.nodes[0], fx.files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(st.clean, []string{"a.txt", "d.txt"}) {
+		t.Errorf("workingRevStatus(0) clean = %v, want a.txt and d.txt", st.clean)
+	}
+}
+
+func TestStatusCopies(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	tests := []struct {
+		rev1, rev2 int
+		want       map[string]string
+	}{
+		{0, 1, map[string]string{"e.txt": "b.txt"}},
+		// e.txt was renamed from b.txt, then modified.
+		{0, 2, map[string]string{"e.txt": "b.txt", "f.txt": "d.txt"}},
+		{1, 2, map[string]string{"f.txt": "d.txt"}},
+		{2, 0, map[string]string{}},
+	}
+	for _, tt := range tests {
+		node1, node2 := fx.nodes[tt.rev1], fx.nodes[tt.rev2]
+		st, err := revStatus(fx.repo, node1, node2, fx.files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copies, err := statusCopies(fx.repo, st, node1, node2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(copies, tt.want) {
+			t.Errorf("statusCopies(%d, %d) = %v, want %v", tt.rev1, tt.rev2, copies, tt.want)
+		}
+	}
+
+	ws, err := workingStatus(fx.repo, fx.files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies, err := statusCopies(fx.repo, ws, ws.parent, wdirNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"g.txt": "a.txt"}; !reflect.DeepEqual(copies, want) {
+		t.Errorf("statusCopies(., wdir) = %v, want %v", copies, want)
+	}
+
+	// Copies committed since changeset 0 are found along with the one of the working directory.
+	st, err := workingRevStatus(fx.repo, ws, fx.nodes[0], fx.files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies, err = statusCopies(fx.repo, st, fx.nodes[0], wdirNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"e.txt": "b.txt", "f.txt": "d.txt", "g.txt": "a.txt"}; !reflect.DeepEqual(copies, want) {
+		t.Errorf("statusCopies(0, wdir) = %v, want %v", copies, want)
+	}
+}
+
+func TestNormalCopyStatus(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	// f.txt is recorded as a copy of d.txt over its unchanged clean entry,
+	// as hg copy --after --force does.
+	ds, err := fx.repo.DirState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ds.Entries {
+		if e.Name == "f.txt" {
+			e.CopySource = "d.txt"
+		}
+	}
+	if err := dirstate.WriteFile(fx.repo.Join("dirstate"), ds); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := workingStatus(fx.repo, fx.files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt", "f.txt"}; !reflect.DeepEqual(ws.modified, want) {
+		t.Fatalf("modified = %v, want %v", ws.modified, want)
+	}
+	copies, err := statusCopies(fx.repo, ws, ws.parent, wdirNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	sw := newStatusWriter(&buf, false, false, false, func(f string) string { return f })
+	printSlice(sw, true, "M", ws.modified, copies, ws.unsure)
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "M a.txt\nM f.txt\n  d.txt\n"; got != want {
+		t.Errorf("status -C printed %q, want %q", got, want)
+	}
+}
+
+func TestTraceCopy(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	mf := make([]manifest.Manifest, len(fx.nodes))
+	for i, node := range fx.nodes {
+		m, err := fx.repo.Manifest(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mf[i] = m
+	}
+
+	tests := []struct {
+		path string
+		rev  int
+		mf   manifest.Manifest
+		want string
+	}{
+		// The modified e.txt goes back to its first revision, renamed from b.txt.
+		{"e.txt", 2, mf[0], "b.txt"},
+		{"e.txt", 2, mf[1], "e.txt"},
+		{"e.txt", 1, mf[0], "b.txt"},
+		{"f.txt", 2, mf[1], "d.txt"},
+		{"a.txt", 2, mf[0], "a.txt"},
+		{"e.txt", 2, manifest.Manifest{}, ""},
+	}
+	for _, tt := range tests {
+		src, err := traceCopy(fx.repo, tt.path, mf[tt.rev][tt.path].Node, tt.mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src != tt.want {
+			t.Errorf("traceCopy(%s@%d) = %q, want %q", tt.path, tt.rev, src, tt.want)
+		}
+	}
+}
+
+func TestWorkingStatusIgnoredDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		removed bool
+		change  func(path string) error
+		want    string
+	}{
+		{"modified", false, func(path string) error { return ioutil.WriteFile(path, []byte("x changed\n"), 0644) }, "M"},
+		{"deleted", false, os.Remove, "!"},
+		{"removed", true, os.Remove, "R"},
+	}
+	for _, tt := range tests {
+		fx := newStatusFixture(t)
+
+		// build/ is ignored, but build/x is tracked: the walk skips build/,
+		// its tracked files are still looked at.
+		writeFiles(t, fx.repo.RootDir, map[string]string{".hgignore": "syntax: glob\nbuild\n", "build/x": "x\n", "build/y": "y\n"})
+		ds, err := fx.repo.DirState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := &dirstate.Entry{Name: "build/x", State: dirstate.Normal, Mode: 0100644, Size: 2, Mtime: dirstate.MtimeUnset}
+		if tt.removed {
+			e = &dirstate.Entry{Name: "build/x", State: dirstate.Removed}
+		}
+		ds.Entries = append(ds.Entries, e)
+		if err := dirstate.WriteFile(fx.repo.Join("dirstate"), ds); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.change(filepath.Join(fx.repo.RootDir, "build", "x")); err != nil {
+			t.Fatal(err)
+		}
+
+		ws, err := workingStatus(fx.repo, fx.files, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fx.close()
+		want := map[string][]string{"M": {"a.txt"}, "A": {"g.txt"}, "?": {".hgignore", "h.txt"}, "C": {"d.txt", "e.txt", "f.txt"}}
+		want[tt.want] = append(want[tt.want], "build/x")
+		sort.Strings(want[tt.want])
+		if got := byStatus(ws); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: workingStatus = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+// fakeFileInfo is the stat data of a file with the given mode.
+type fakeFileInfo struct {
+	os.FileInfo
+	mode os.FileMode
+}
+
+func (fi fakeFileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func TestModeChanged(t *testing.T) {
+	tests := []struct {
+		recorded uint32
+		mode     os.FileMode
+		want     bool
+	}{
+		{0100644, 0644, false},
+		// Permission bits other than the exec bit don't matter.
+		{0100644, 0600, false},
+		{0100755, 0711, false},
+		{0100644, 0755, true},
+		{0100755, 0644, true},
+		{0100644, os.ModeSymlink | 0777, true},
+		{0120777, 0644, true},
+		{0120777, os.ModeSymlink | 0777, false},
+	}
+	for _, tt := range tests {
+		if got := modeChanged(tt.recorded, fakeFileInfo{mode: tt.mode}); got != tt.want {
+			t.Errorf("modeChanged(%#o, %v) = %v, want %v", tt.recorded, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestWorkingStatusModes(t *testing.T) {
+	symlink := func(target string) func(string) error {
+		return func(path string) error {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			return os.Symlink(target, path)
+		}
+	}
+
+	// d.txt is recorded as "d\n", 2 bytes, or as a symlink of that size.
+	tests := []struct {
+		name     string
+		recorded uint32
+		change   func(path string) error
+		modified bool
+		unsure   string
+	}{
+		{"exec bit set", 0, func(path string) error { return os.Chmod(path, 0755) }, true, ""},
+		{"file to symlink", 0, symlink("xx"), true, ""},
+		{"symlink to file", 0120777, nil, true, ""},
+		// The size of a symlink isn't always the length of its target.
+		{"symlink size", 0120777, symlink("xyz"), true, "symlink size changed"},
+	}
+	for _, tt := range tests {
+		fx := newStatusFixture(t)
+		path := filepath.Join(fx.repo.RootDir, "d.txt")
+		if tt.recorded != 0 {
+			ds, err := fx.repo.DirState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range ds.Entries {
+				if e.Name == "d.txt" {
+					e.Mode = tt.recorded
+				}
+			}
+			if err := dirstate.WriteFile(fx.repo.Join("dirstate"), ds); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.change != nil {
+			if err := tt.change(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ws, err := workingStatus(fx.repo, fx.files, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fx.close()
+		modified := false
+		for _, f := range ws.modified {
+			modified = modified || f == "d.txt"
+		}
+		if modified != tt.modified {
+			t.Errorf("%s: d.txt modified = %v, want %v", tt.name, modified, tt.modified)
+		}
+		if ws.unsure["d.txt"] != tt.unsure {
+			t.Errorf("%s: d.txt unsure = %q, want %q", tt.name, ws.unsure["d.txt"], tt.unsure)
+		}
+	}
+}
+
+func TestCheckLookup(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	// d.txt and f.txt are touched only, e.txt is changed without changing its size.
+	writeFiles(t, fx.repo.RootDir, map[string]string{"d.txt": "d\n", "e.txt": "b3\n", "f.txt": "d\n"})
+	files := []string{"d.txt", "e.txt", "f.txt", "g.txt"}
+	stats := radix.New()
+	for _, f := range files {
+		info, err := os.Lstat(filepath.Join(fx.repo.RootDir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.Insert(f, info)
+	}
+
+	modified, clean, err := checkLookup(fx.repo, fx.nodes[2], files, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// f.txt is compared without the copy metadata stored in its filelog,
+	// g.txt is not in the manifest.
+	if want := []string{"e.txt", "g.txt"}; !reflect.DeepEqual(modified, want) {
+		t.Errorf("modified = %v, want %v", modified, want)
+	}
+	if want := []string{"d.txt", "f.txt"}; !reflect.DeepEqual(clean, want) {
+		t.Errorf("clean = %v, want %v", clean, want)
+	}
+
+	// Against changeset 0, e.txt and f.txt do not exist and d.txt is unchanged.
+	modified, clean, err = checkLookup(fx.repo, fx.nodes[0], files, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e.txt", "f.txt", "g.txt"}; !reflect.DeepEqual(modified, want) {
+		t.Errorf("modified against 0 = %v, want %v", modified, want)
+	}
+	if want := []string{"d.txt"}; !reflect.DeepEqual(clean, want) {
+		t.Errorf("clean against 0 = %v, want %v", clean, want)
+	}
+}
+
+func TestFileChangedFlags(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	m, err := fx.repo.Manifest(fx.nodes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(fx.repo.RootDir, "d.txt")
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The content is the same, but the file became executable.
+	changed, err := fileChanged(fx.repo, "d.txt", m["d.txt"], info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("fileChanged(d.txt) = false after chmod +x, want true")
+	}
+}
+
+func TestFixupDirState(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	// d.txt is touched only, e.txt is changed without changing its size,
+	// both long enough ago for their mtimes not to be ambiguous.
+	writeFiles(t, fx.repo.RootDir, map[string]string{"e.txt": "b3\n"})
+	mtime := time.Unix(1600000000, 0)
+	for _, f := range []string{"d.txt", "e.txt"} {
+		if err := os.Chtimes(filepath.Join(fx.repo.RootDir, f), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws, err := workingStatus(fx.repo, fx.files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt", "e.txt"}; !reflect.DeepEqual(ws.modified, want) {
+		t.Errorf("modified = %v, want %v", ws.modified, want)
+	}
+
+	ds, err := fx.repo.DirState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ds.Entries {
+		switch e.Name {
+		case "d.txt":
+			if e.Mtime != int32(mtime.Unix()) {
+				t.Errorf("clean d.txt has mtime %d in the dirstate, want %d", e.Mtime, mtime.Unix())
+			}
+		case "e.txt":
+			if e.Mtime != 1500000000 {
+				t.Errorf("modified e.txt has mtime %d in the dirstate, want it unchanged", e.Mtime)
+			}
+		}
+	}
+}
+
+// v2NodeFlags reads the dirstate-v2 docket and data file of the repository at root
+// and returns the path and content of the data file and the offsets of the flags
+// of each node, by path.
+func v2NodeFlags(t *testing.T, root string) (dataPath string, data []byte, flagsAt map[string]int) {
+	t.Helper()
+	docket, err := ioutil.ReadFile(filepath.Join(root, ".hg", "dirstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Marker, parents, then the tree metadata, the data size and the uid.
+	meta := docket[12+2*32:]
+	dataPath = filepath.Join(root, ".hg", "dirstate."+string(meta[49:49+int(meta[48])]))
+	data, err = ioutil.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flagsAt = make(map[string]int)
+	var walk func(start, count uint32)
+	walk = func(start, count uint32) {
+		for i := uint32(0); i < count; i++ {
+			n := data[start+i*44:]
+			pathStart := binary.BigEndian.Uint32(n[0:4])
+			path := string(data[pathStart : pathStart+uint32(binary.BigEndian.Uint16(n[4:6]))])
+			flagsAt[path] = int(start + i*44 + 30)
+			walk(binary.BigEndian.Uint32(n[14:18]), binary.BigEndian.Uint32(n[18:22]))
+		}
+	}
+	walk(binary.BigEndian.Uint32(meta[0:4]), binary.BigEndian.Uint32(meta[4:8]))
+	return dataPath, data, flagsAt
+}
+
+func TestFixupDirStateV2(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	root := fx.repo.RootDir
+	ds, err := fx.repo.DirState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{".hg/requires": "revlogv1\nstore\ndirstate-v2\n"})
+	if err := dirstate.WriteFileV2(filepath.Join(root, ".hg", "dirstate"), ds); err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := func(data []byte, flagsAt map[string]int) map[string]uint16 {
+		m := make(map[string]uint16)
+		for path, at := range flagsAt {
+			m[path] = binary.BigEndian.Uint16(data[at:])
+		}
+		return m
+	}
+	touch := func(sec int64) {
+		mtime := time.Unix(sec, 0)
+		if err := os.Chtimes(filepath.Join(root, "d.txt"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// d.txt is touched only: its new mtime is recorded, and the flags of
+	// every node are written back unchanged.
+	_, data, flagsAt := v2NodeFlags(t, root)
+	before := flags(data, flagsAt)
+	touch(1600000000)
+	if _, err := workingStatus(r, fx.files, false); err != nil {
+		t.Fatal(err)
+	}
+	dataPath, data, flagsAt := v2NodeFlags(t, root)
+	if after := flags(data, flagsAt); !reflect.DeepEqual(after, before) {
+		t.Errorf("node flags after fixup = %v, want %v", after, before)
+	}
+	if got := int32(binary.BigEndian.Uint32(data[flagsAt["d.txt"]+6:])); got != 1600000000 {
+		t.Errorf("clean d.txt has mtime %d in the dirstate, want 1600000000", got)
+	}
+
+	// A flag the entries have no room for keeps the dirstate from being rewritten.
+	const expectedStateIsModified = 1 << 9
+	at := flagsAt["f.txt"]
+	binary.BigEndian.PutUint16(data[at:], binary.BigEndian.Uint16(data[at:])|expectedStateIsModified)
+	docket, err := ioutil.ReadFile(filepath.Join(root, ".hg", "dirstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dataPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	touch(1600000100)
+	if _, err := workingStatus(r, fx.files, false); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ioutil.ReadFile(filepath.Join(root, ".hg", "dirstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, docket) {
+		t.Error("dirstate-v2 with an expected-state-is-modified node was rewritten")
+	}
+	if got, err := ioutil.ReadFile(dataPath); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("dirstate-v2 data file changed: %v", err)
+	}
+}
+
+func TestFileChangedEncodedPath(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	// The filelog of Makefile is stored as data/_makefile.i.
+	nodes := writeRevlog(t, filepath.Join(fx.repo.RootDir, ".hg", "store", "data", "_makefile.i"),
+		revision{"all:\n", -1, -1},
+	)
+	writeFiles(t, fx.repo.RootDir, map[string]string{"Makefile": "all:\n"})
+	info, err := os.Lstat(filepath.Join(fx.repo.RootDir, "Makefile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := fileChanged(fx.repo, "Makefile", manifest.File{Node: nodes[0]}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("fileChanged(Makefile) = true for unchanged content, want false")
+	}
+}
+
+func TestAddSubrepoStatus(t *testing.T) {
+	fx := newStatusFixture(t)
+	defer fx.close()
+
+	// The fixture is the subrepository sub of a repository without changesets.
+	top, err := ioutil.TempDir("", "status-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(top)
+	writeFiles(t, top, map[string]string{".hg/requires": "revlogv1\nstore\n", ".hgsub": "sub = sub\n"})
+	if err := os.Rename(fx.repo.RootDir, filepath.Join(top, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.Open(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := match.NewFileMatcher(r.RootDir, r.RootDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		revision string
+		want     map[string][]string
+		copies   map[string]string
+	}{
+		{
+			// Without .hgsubstate, the subrepository is compared to its parent.
+			"",
+			map[string][]string{"M": {"sub/a.txt"}, "A": {"sub/g.txt"}, "?": {".hgsub", "sub/h.txt"}, "C": {"sub/d.txt", "sub/e.txt", "sub/f.txt"}},
+			map[string]string{"sub/g.txt": "sub/a.txt"},
+		},
+		{
+			fx.nodes[2].String(),
+			map[string][]string{"M": {"sub/a.txt"}, "A": {"sub/g.txt"}, "?": {".hgsub", ".hgsubstate", "sub/h.txt"}, "C": {"sub/d.txt", "sub/e.txt", "sub/f.txt"}},
+			map[string]string{"sub/g.txt": "sub/a.txt"},
+		},
+		{
+			fx.nodes[0].String(),
+			map[string][]string{"M": {"sub/a.txt"}, "A": {"sub/e.txt", "sub/f.txt", "sub/g.txt"}, "R": {"sub/b.txt"}, "?": {".hgsub", ".hgsubstate", "sub/h.txt"}, "C": {"sub/d.txt"}},
+			map[string]string{"sub/e.txt": "sub/b.txt", "sub/f.txt": "sub/d.txt", "sub/g.txt": "sub/a.txt"},
+		},
+		{
+			// Subrepositories whose revision is unknown are skipped with a warning.
+			"ffffffffffffffffffffffffffffffffffffffff",
+			map[string][]string{"?": {".hgsub", ".hgsubstate"}},
+			map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		if tt.revision != "" {
+			writeFiles(t, top, map[string]string{".hgsubstate": tt.revision + " sub\n"})
+		}
+
+		st, err := workingStatus(r, files, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := addSubrepoStatus(r, st, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got := byStatus(st); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("revision %q: status = %v, want %v", tt.revision, got, tt.want)
+		}
+
+		copies, err := statusCopies(r, st, st.parent, wdirNode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(copies, tt.copies) {
+			t.Errorf("revision %q: copies = %v, want %v", tt.revision, copies, tt.copies)
+		}
+	}
+}
+
+func TestStatusVerbose(t *testing.T) {
+	defer noUserConfig()()
+	root := newMergeRepo(t)
+	defer os.RemoveAll(root)
+	defer chdir(t, root)()
+	writeFiles(t, root, map[string]string{".hg/rebasestate": ""})
+
+	var code int
+	got := captureOutput(t, &os.Stdout, func() {
+		code = (&StatusCommand{}).Run([]string{"-v"})
+	})
+	if code != 0 {
+		t.Errorf("status -v exited with %d", code)
+	}
+	want := `? a.txt
+? dir/c.txt
+# The repository is in an unfinished *rebase* state.
+
+# Unresolved merge conflicts:
+# 
+#     a.txt
+#     dir/c.txt
+# 
+# To mark files as resolved:  hg resolve --mark FILE
+
+# To continue:    hg rebase --continue
+# To abort:       hg rebase --abort
+# To stop:        hg rebase --stop
+
+`
+	if got != want {
+		t.Errorf("status -v printed:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without -v, the conflicts are only warned about.
+	var stderr string
+	got = captureOutput(t, &os.Stdout, func() {
+		stderr = captureOutput(t, &os.Stderr, func() { (&StatusCommand{}).Run(nil) })
+	})
+	if want := "? a.txt\n? dir/c.txt\n"; got != want {
+		t.Errorf("status printed %q, want %q", got, want)
+	}
+	if want := "warning: 2 unresolved merge conflicts (see 'hgo resolve --list')\n"; stderr != want {
+		t.Errorf("status warned %q, want %q", stderr, want)
+	}
+}
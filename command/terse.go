@@ -0,0 +1,90 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	radix "github.com/armon/go-radix"
+)
+
+// terseStatuses are the status letters --terse accepts, in the order of statusLists.
+const terseStatuses = "marduic"
+
+// checkTerse validates the argument of --terse.
+func checkTerse(terse string) error {
+	for _, c := range terse {
+		if !strings.ContainsRune(terseStatuses, c) {
+			return fmt.Errorf("'%c' not recognized", c)
+		}
+	}
+	return nil
+}
+
+// terseDirs collapses directories whose files all have the same status into
+// a single "dir/" entry, for the statuses listed in terse.
+// Files at the root of the repository are never collapsed.
+// Ignored files are left alone unless terse lists them: Mercurial
+// doesn't collect them otherwise, so they don't keep directories apart.
+//
+// Mechanical translation of cmdutil.tersedir.
+func terseDirs(st *statusLists, terse string) {
+	lists := []*[]string{&st.modified, &st.added, &st.removed, &st.deleted, &st.unknown, &st.ignored, &st.clean}
+
+	// Every file with its status letter, in path order.
+	files := radix.New()
+	for i, l := range lists {
+		if terseStatuses[i] == 'i' && !strings.Contains(terse, "i") {
+			continue
+		}
+		for _, f := range *l {
+			files.Insert(f, terseStatuses[i])
+		}
+		*l = nil
+	}
+
+	// The statuses found below each directory.
+	dirStatuses := make(map[string]string)
+	files.Walk(func(f string, raw interface{}) bool {
+		status := string(raw.(byte))
+		for dir := parentDir(f); dir != ""; dir = parentDir(dir) {
+			if !strings.Contains(dirStatuses[dir], status) {
+				dirStatuses[dir] += status
+			}
+		}
+		return false
+	})
+
+	// A file goes into its topmost directory that collapses, or stays on its own.
+	files.Walk(func(f string, raw interface{}) bool {
+		status := raw.(byte)
+		entry := f
+		for _, dir := range ancestors(f) {
+			if s := dirStatuses[dir]; len(s) == 1 && strings.Contains(terse, s) {
+				entry = dir + "/"
+				break
+			}
+		}
+
+		l := lists[strings.IndexByte(terseStatuses, status)]
+		if n := len(*l); n == 0 || (*l)[n-1] != entry {
+			*l = append(*l, entry)
+		}
+		return false
+	})
+
+	for _, l := range lists {
+		sort.Strings(*l)
+	}
+}
+
+// ancestors returns the directories of a slash-separated path, the topmost first.
+func ancestors(path string) []string {
+	var dirs []string
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			dirs = append(dirs, path[:i])
+		}
+	}
+	return dirs
+}
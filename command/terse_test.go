@@ -0,0 +1,129 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerseDirs(t *testing.T) {
+	tests := []struct {
+		name  string
+		terse string
+		in    statusLists
+		want  statusLists
+	}{
+		{
+			name:  "unknown dir",
+			terse: "u",
+			in:    statusLists{unknown: []string{"a.txt", "dir/x", "dir/y"}},
+			want:  statusLists{unknown: []string{"a.txt", "dir/"}},
+		},
+		{
+			name:  "root files",
+			terse: "u",
+			in:    statusLists{unknown: []string{"x", "y"}},
+			want:  statusLists{unknown: []string{"x", "y"}},
+		},
+		{
+			name:  "mixed statuses",
+			terse: "mu",
+			in:    statusLists{modified: []string{"dir/a"}, unknown: []string{"dir/b"}},
+			want:  statusLists{modified: []string{"dir/a"}, unknown: []string{"dir/b"}},
+		},
+		{
+			name:  "status not tersed",
+			terse: "m",
+			in:    statusLists{unknown: []string{"dir/x", "dir/y"}},
+			want:  statusLists{unknown: []string{"dir/x", "dir/y"}},
+		},
+		{
+			name:  "nested dir",
+			terse: "u",
+			in:    statusLists{modified: []string{"top/m"}, unknown: []string{"top/sub/deep/x", "top/sub/y"}},
+			want:  statusLists{modified: []string{"top/m"}, unknown: []string{"top/sub/"}},
+		},
+		{
+			name:  "topmost dir",
+			terse: "u",
+			in:    statusLists{unknown: []string{"top/a", "top/sub/b", "top/sub/deep/c"}},
+			want:  statusLists{unknown: []string{"top/"}},
+		},
+		{
+			name:  "ignored and clean",
+			terse: "ic",
+			in:    statusLists{ignored: []string{"build/a.o", "build/obj/b.o"}, clean: []string{"lib/a", "lib/b", "x"}},
+			want:  statusLists{ignored: []string{"build/"}, clean: []string{"lib/", "x"}},
+		},
+		{
+			name:  "ignored only",
+			terse: "i",
+			in:    statusLists{ignored: []string{"build/a.o"}, clean: []string{"lib/a", "lib/b"}},
+			want:  statusLists{ignored: []string{"build/"}, clean: []string{"lib/a", "lib/b"}},
+		},
+		{
+			name:  "ignored not tersed",
+			terse: "u",
+			in:    statusLists{unknown: []string{"dir/x", "dir/y"}, ignored: []string{"dir/x.o", "obj/a.o", "obj/b.o"}},
+			want:  statusLists{unknown: []string{"dir/"}, ignored: []string{"dir/x.o", "obj/a.o", "obj/b.o"}},
+		},
+		{
+			name:  "every status",
+			terse: "marduic",
+			in: statusLists{
+				modified: []string{"m/a"}, added: []string{"a/a"}, removed: []string{"r/a"}, deleted: []string{"d/a"},
+				unknown: []string{"u/a"}, ignored: []string{"i/a"}, clean: []string{"c/a", "m/b"},
+			},
+			want: statusLists{
+				added: []string{"a/"}, removed: []string{"r/"}, deleted: []string{"d/"},
+				unknown: []string{"u/"}, ignored: []string{"i/"}, clean: []string{"c/", "m/b"}, modified: []string{"m/a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		st := tt.in
+		terseDirs(&st, tt.terse)
+		if !reflect.DeepEqual(byStatus(&st), byStatus(&tt.want)) {
+			t.Errorf("%s: terseDirs(%q) = %v, want %v", tt.name, tt.terse, byStatus(&st), byStatus(&tt.want))
+		}
+	}
+}
+
+func TestCheckTerse(t *testing.T) {
+	for _, terse := range []string{"", "u", "marduic", "cim"} {
+		if err := checkTerse(terse); err != nil {
+			t.Errorf("checkTerse(%q) = %v", terse, err)
+		}
+	}
+
+	tests := []struct {
+		terse string
+		err   string
+	}{
+		{"x", "'x' not recognized"},
+		{"mz", "'z' not recognized"},
+		{"M", "'M' not recognized"},
+		{"m,u", "',' not recognized"},
+	}
+	for _, tt := range tests {
+		if err := checkTerse(tt.terse); err == nil || err.Error() != tt.err {
+			t.Errorf("checkTerse(%q) = %v, want %s", tt.terse, err, tt.err)
+		}
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"a.txt", nil},
+		{"dir/a.txt", []string{"dir"}},
+		{"a/b/c/d.txt", []string{"a", "a/b", "a/b/c"}},
+	}
+	for _, tt := range tests {
+		if got := ancestors(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ancestors(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
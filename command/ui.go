@@ -1,9 +1,73 @@
 package command
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sashka/hgo/config"
+	"github.com/sashka/hgo/match"
+	"github.com/sashka/hgo/repo"
+)
 
 // Abort print an error and return 255.
 func Abort(format string, a ...interface{}) int {
 	fmt.Printf("abort: "+format, a...)
 	return 255
 }
+
+// hinter is implemented by errors that come with a hint for the user.
+type hinter interface {
+	Hint() string
+}
+
+// AbortErr prints err like Abort("%s!\n", err), followed by its hint
+// in parentheses when it has one, and returns 255.
+func AbortErr(err error) int {
+	fmt.Printf("abort: %s!\n", err)
+	var h hinter
+	if errors.As(err, &h) {
+		fmt.Printf("(%s)\n", h.Hint())
+	}
+	return 255
+}
+
+// AbortParseError prints an error met while loading the configuration and returns 255.
+// Syntax errors are printed the way Mercurial does, without the abort prefix:
+// "hgo: parse error at FILE:LINE: MESSAGE". Other errors are printed by AbortErr.
+func AbortParseError(err error) int {
+	var pe *config.ParseError
+	if !errors.As(err, &pe) {
+		return AbortErr(err)
+	}
+	fmt.Printf("hgo: %s\n", pe)
+	return 255
+}
+
+// uiPathFunc returns the function turning repository paths into the paths shown to the user:
+// relative to the current directory wd or to the root, as ui.relative-paths says.
+// legacyRelative is the choice of its default "legacy" value, and the command's
+// commands.<relativeItem> setting, if any, overrides it.
+//
+// Mechanical translation of scmutil.getuipathfn.
+func uiPathFunc(r *repo.Repo, wd string, legacyRelative bool, relativeItem string) (func(string) string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	relative := legacyRelative
+	if _, ok := cfg.Get("commands", relativeItem); ok && relativeItem != "" {
+		relative, err = cfg.Bool("commands", relativeItem, false)
+	} else if v, ok := cfg.Get("ui", "relative-paths"); ok && v != "legacy" {
+		relative, err = cfg.Bool("ui", "relative-paths", false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cwd, err := match.CanonPath(r.RootDir, wd, wd)
+	if err != nil || !relative {
+		return func(f string) string { return f }, nil
+	}
+	return func(f string) string { return match.PathTo(cwd, f) }, nil
+}
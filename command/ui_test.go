@@ -0,0 +1,141 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashka/hgo/config"
+	"github.com/sashka/hgo/repo"
+)
+
+// noUserConfig makes repositories read their own hgrc only,
+// and returns a function restoring HGRCPATH.
+func noUserConfig() func() {
+	old, set := os.LookupEnv("HGRCPATH")
+	os.Setenv("HGRCPATH", "")
+	return func() {
+		if set {
+			os.Setenv("HGRCPATH", old)
+		} else {
+			os.Unsetenv("HGRCPATH")
+		}
+	}
+}
+
+func TestUIPathFunc(t *testing.T) {
+	defer noUserConfig()()
+
+	tests := []struct {
+		hgrc           string
+		wd             string
+		legacyRelative bool
+		relativeItem   string
+		want           string
+	}{
+		{"", "sub", false, "status.relative", "dir/a.txt"},
+		// Explicit patterns make paths relative by default.
+		{"", "sub", true, "status.relative", "../dir/a.txt"},
+		{"", ".", true, "status.relative", "dir/a.txt"},
+		{"", "dir", true, "status.relative", "a.txt"},
+		{"", "dir/deeper", true, "", "../a.txt"},
+		{"[ui]\nrelative-paths = yes\n", "sub", false, "status.relative", "../dir/a.txt"},
+		{"[ui]\nrelative-paths = no\n", "sub", true, "status.relative", "dir/a.txt"},
+		{"[ui]\nrelative-paths = legacy\n", "sub", true, "status.relative", "../dir/a.txt"},
+		{"[ui]\nrelative-paths = legacy\n", "sub", false, "status.relative", "dir/a.txt"},
+		// The setting of the command overrides ui.relative-paths.
+		{"[ui]\nrelative-paths = yes\n[commands]\nstatus.relative = no\n", "sub", false, "status.relative", "dir/a.txt"},
+		{"[commands]\nstatus.relative = yes\n", "sub", false, "status.relative", "../dir/a.txt"},
+		// Commands without a setting of their own only follow ui.relative-paths.
+		{"[commands]\nstatus.relative = yes\n", "sub", false, "", "dir/a.txt"},
+	}
+	for _, tt := range tests {
+		root, err := ioutil.TempDir("", "ui-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, root, map[string]string{".hg/hgrc": tt.hgrc, "dir/deeper/b.txt": "", "sub/c.txt": ""})
+		r, err := repo.Open(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		uipath, err := uiPathFunc(r, filepath.Join(root, filepath.FromSlash(tt.wd)), tt.legacyRelative, tt.relativeItem)
+		os.RemoveAll(root)
+		if err != nil {
+			t.Errorf("hgrc %q: %s", tt.hgrc, err)
+			continue
+		}
+		if got := uipath("dir/a.txt"); got != tt.want {
+			t.Errorf("hgrc %q, wd %s, legacyRelative %v, item %q: got %s, want %s", tt.hgrc, tt.wd, tt.legacyRelative, tt.relativeItem, got, tt.want)
+		}
+	}
+}
+
+func TestUIPathFuncBadBool(t *testing.T) {
+	defer noUserConfig()()
+
+	root, err := ioutil.TempDir("", "ui-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{".hg/hgrc": "[ui]\nrelative-paths = maybe\n"})
+	r, err := repo.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uiPathFunc(r, root, false, "status.relative"); err == nil {
+		t.Error("uiPathFunc accepted ui.relative-paths = maybe")
+	}
+}
+
+func TestAbortParseError(t *testing.T) {
+	perr := &config.ParseError{Source: ".hg/hgrc", Line: 2, Message: "unexpected leading whitespace:   x"}
+	want := "hgo: parse error at .hg/hgrc:2: unexpected leading whitespace:   x\n"
+
+	if got := captureOutput(t, &os.Stdout, func() { AbortParseError(perr) }); got != want {
+		t.Errorf("AbortParseError printed %q, want %q", got, want)
+	}
+	if got := captureOutput(t, &os.Stdout, func() { AbortParseError(fmt.Errorf("reading config: %w", perr)) }); got != want {
+		t.Errorf("AbortParseError(wrapped) printed %q, want %q", got, want)
+	}
+	if got, want := captureOutput(t, &os.Stdout, func() { AbortParseError(os.ErrPermission) }), "abort: permission denied!\n"; got != want {
+		t.Errorf("AbortParseError(other error) printed %q, want %q", got, want)
+	}
+	// Abort formats its arguments as they are.
+	if got, want := captureOutput(t, &os.Stdout, func() { Abort("%s\n", perr) }), "abort: "+want[len("hgo: "):]; got != want {
+		t.Errorf("Abort printed %q, want %q", got, want)
+	}
+}
+
+func TestCommandParseError(t *testing.T) {
+	defer noUserConfig()()
+
+	root, err := ioutil.TempDir("", "ui-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{".hg/hgrc": "[ui]\n  x\n"})
+	defer chdir(t, root)()
+
+	want := "hgo: parse error at " + filepath.Join(root, ".hg", "hgrc") + ":2: unexpected leading whitespace:   x\n"
+	tests := []struct {
+		cmd  interface{ Run([]string) int }
+		args []string
+	}{
+		{&StatusCommand{}, nil},
+		{&ResolveCommand{}, []string{"--list"}},
+		{&DebugIgnoreCommand{}, nil},
+	}
+	for _, tt := range tests {
+		var code int
+		got := captureOutput(t, &os.Stdout, func() { code = tt.cmd.Run(tt.args) })
+		if got != want || code != 255 {
+			t.Errorf("%T printed %q and exited with %d, want %q and 255", tt.cmd, got, code, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	radix "github.com/armon/go-radix"
+)
+
+// walkOptions tune a working directory walk.
+type walkOptions struct {
+	// workers is the number of directories read concurrently.
+	workers int
+
+	// needStat reports whether the stat data of a file is needed.
+	// Files it rejects are still reported, with nil stat data.
+	needStat func(path string) bool
+
+	// skipDir reports whether a directory and everything below it should be left out.
+	skipDir func(dir string) bool
+
+	// roots limits the walk to these files and directories, relative to root.
+	// Roots that don't exist or are inside a nested repository are left out. Nil means the whole working directory.
+	roots []string
+}
+
+// walkError is a directory the walk failed to read.
+type walkError struct {
+	path string
+	err  error
+}
+
+// walkWorkingDir walks the working directory at root with a pool of workers.
+//
+// It returns every file found, keyed by its slash-separated path relative to root,
+// with os.FileInfo values from the directory entries, so that no file is stat'ed twice.
+// The .hg directory at root is skipped, and nested repositories are not entered.
+func walkWorkingDir(root string, opts walkOptions) (*radix.Tree, []walkError) {
+	if opts.workers <= 0 {
+		opts.workers = 2 * runtime.GOMAXPROCS(0)
+	}
+
+	w := &walker{
+		root: root,
+		opts: opts,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	// Files given as roots are reported as found, directories are queued.
+	var rootFiles []walkResult
+	if opts.roots == nil {
+		w.queue = []string{""}
+	}
+	for _, r := range opts.roots {
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(r)))
+		if err != nil || inNestedRepo(root, r) {
+			continue
+		}
+		if info.IsDir() {
+			w.queue = append(w.queue, r)
+			continue
+		}
+		if opts.needStat != nil && !opts.needStat(r) {
+			info = nil
+		}
+		rootFiles = append(rootFiles, walkResult{path: r, info: info})
+	}
+	w.pending = len(w.queue)
+
+	results := make([][]walkResult, opts.workers, opts.workers+1)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = w.work()
+		}(i)
+	}
+	wg.Wait()
+	results = append(results, rootFiles)
+
+	// Insertion order doesn't matter to the radix tree, so the result is deterministic.
+	found := radix.New()
+	for _, rs := range results {
+		for _, r := range rs {
+			found.Insert(r.path, r.info)
+		}
+	}
+
+	return found, w.errs
+}
+
+// inNestedRepo reports whether path is inside a repository nested in the one at root.
+func inNestedRepo(root, path string) bool {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(path[:i]), ".hg"))
+		if err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+type walkResult struct {
+	path string
+	info os.FileInfo
+}
+
+type walker struct {
+	root string
+	opts walkOptions
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds directories waiting to be read.
+	queue []string
+	// pending counts directories queued or being read.
+	pending int
+	errs    []walkError
+}
+
+// work reads directories from the queue until the walk is complete.
+func (w *walker) work() []walkResult {
+	var results []walkResult
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.cond.Wait()
+		}
+		if w.pending == 0 {
+			w.mu.Unlock()
+			return results
+		}
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		results = w.readDir(dir, results)
+
+		w.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// readDir appends the files of dir to results and queues its subdirectories.
+func (w *walker) readDir(dir string, results []walkResult) []walkResult {
+	entries, err := ioutil.ReadDir(filepath.Join(w.root, filepath.FromSlash(dir)))
+	if err != nil {
+		w.mu.Lock()
+		w.errs = append(w.errs, walkError{path: dir, err: err})
+		w.mu.Unlock()
+		return results
+	}
+
+	// A directory holding .hg is a nested repository, whose files are not ours.
+	if dir != "" {
+		for _, e := range entries {
+			if e.Name() == ".hg" && e.IsDir() {
+				return results
+			}
+		}
+	}
+
+	var subdirs []string
+	for _, e := range entries {
+		path := e.Name()
+		if dir != "" {
+			path = dir + "/" + path
+		}
+
+		if e.IsDir() {
+			// Skip .hg and all its files and subdirs unconditionally.
+			if path == ".hg" {
+				continue
+			}
+			if w.opts.skipDir != nil && w.opts.skipDir(path) {
+				continue
+			}
+			subdirs = append(subdirs, path)
+			continue
+		}
+
+		info := e
+		if w.opts.needStat != nil && !w.opts.needStat(path) {
+			info = nil
+		}
+		results = append(results, walkResult{path: path, info: info})
+	}
+
+	if len(subdirs) > 0 {
+		w.mu.Lock()
+		w.queue = append(w.queue, subdirs...)
+		w.pending += len(subdirs)
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+
+	return results
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sequentialWalk lists the files below root the way walkWorkingDir should,
+// with filepath.Walk.
+func sequentialWalk(t *testing.T, root string, skipDir func(string) bool) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !info.IsDir() {
+			files = append(files, rel)
+			return nil
+		}
+		if rel == "." {
+			return nil
+		}
+		if rel == ".hg" || skipDir(rel) {
+			return filepath.SkipDir
+		}
+		if nested, err := os.Stat(filepath.Join(path, ".hg")); err == nil && nested.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestWalkWorkingDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "walk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		".hg/requires":          "store\n",
+		".hgignore":             "build\n",
+		"a.txt":                 "a",
+		"src/main.go":           "main",
+		"src/lib/lib.go":        "lib",
+		"src/lib/deep/x/y/z.go": "z",
+		"docs/index.md":         "docs",
+		"build/out.o":           "out",
+		"build/obj/lib.o":       "lib",
+		"src/build/gen.go":      "gen",
+		"nested/.hg/requires":   "store\n",
+		"nested/inner.txt":      "inner",
+	})
+
+	// build and everything below it are ignored.
+	var mu sync.Mutex
+	var skipped []string
+	skipDir := func(dir string) bool {
+		if strings.HasPrefix(dir, "build/") {
+			t.Errorf("skipDir(%q) called below a pruned directory", dir)
+		}
+		if dir == "build" {
+			mu.Lock()
+			skipped = append(skipped, dir)
+			mu.Unlock()
+			return true
+		}
+		return false
+	}
+
+	want := sequentialWalk(t, root, skipDir)
+	skipped = nil
+
+	for _, workers := range []int{1, 2, 8} {
+		found, errs := walkWorkingDir(root, walkOptions{workers: workers, skipDir: skipDir})
+		if len(errs) > 0 {
+			t.Errorf("workers=%d: walk errors: %v", workers, errs)
+		}
+
+		var got []string
+		found.Walk(func(path string, raw interface{}) bool {
+			got = append(got, path)
+			if raw == nil {
+				t.Errorf("workers=%d: %s has no stat data", workers, path)
+			}
+			return false
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("workers=%d: walkWorkingDir = %v, want %v", workers, got, want)
+		}
+	}
+
+	if !reflect.DeepEqual(skipped, []string{"build", "build", "build"}) {
+		t.Errorf("pruned directories = %v, want build once per walk", skipped)
+	}
+	for _, f := range want {
+		if strings.HasPrefix(f, "build/") || strings.HasPrefix(f, "nested/") || strings.HasPrefix(f, ".hg/") {
+			t.Errorf("sequential walk found %s", f)
+		}
+	}
+}
+
+func TestWalkWorkingDirNeedStat(t *testing.T) {
+	root, err := ioutil.TempDir("", "walk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+	})
+
+	found, _ := walkWorkingDir(root, walkOptions{needStat: func(path string) bool { return path == "a.txt" }})
+	if raw, ok := found.Get("a.txt"); !ok || raw == nil {
+		t.Errorf("a.txt = %v, %v, want stat data", raw, ok)
+	}
+	if raw, ok := found.Get("dir/b.txt"); !ok || raw != nil {
+		t.Errorf("dir/b.txt = %v, %v, want found without stat data", raw, ok)
+	}
+}
@@ -0,0 +1,292 @@
+// Package config reads Mercurial configuration files (hgrc).
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Item is a single configuration value.
+type Item struct {
+	Name  string
+	Value string
+
+	// Source is "file:line" where the value was set.
+	Source string
+}
+
+type section struct {
+	// names keeps the order in which items were last set.
+	names []string
+	items map[string]Item
+}
+
+// Config holds configuration values by section.
+type Config struct {
+	sections map[string]*section
+}
+
+// New returns an empty configuration.
+func New() *Config {
+	return &Config{sections: make(map[string]*section)}
+}
+
+// ParseError is a syntax error in a configuration file.
+type ParseError struct {
+	Source  string
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at %s:%d: %s", e.Source, e.Line, e.Message)
+}
+
+// Get returns the value of name in section.
+func (c *Config) Get(section, name string) (string, bool) {
+	s, ok := c.sections[section]
+	if !ok {
+		return "", false
+	}
+	item, ok := s.items[name]
+	return item.Value, ok
+}
+
+// Bool returns the value of name in section as a boolean, def when it is not set.
+func (c *Config) Bool(section, name string, def bool) (bool, error) {
+	v, ok := c.Get(section, name)
+	if !ok {
+		return def, nil
+	}
+	b, ok := ParseBool(v)
+	if !ok {
+		return false, fmt.Errorf("config error: %s.%s is not a boolean ('%s')", section, name, v)
+	}
+	return b, nil
+}
+
+// ParseBool parses a boolean value the way Mercurial does, ok is false
+// for values that are not booleans.
+func ParseBool(s string) (b, ok bool) {
+	switch strings.ToLower(s) {
+	case "1", "yes", "true", "on", "always":
+		return true, true
+	case "0", "no", "false", "off", "never":
+		return false, true
+	}
+	return false, false
+}
+
+// Items returns the items of section in the order they were set.
+func (c *Config) Items(section string) []Item {
+	s, ok := c.sections[section]
+	if !ok {
+		return nil
+	}
+	items := make([]Item, 0, len(s.names))
+	for _, name := range s.names {
+		items = append(items, s.items[name])
+	}
+	return items
+}
+
+// Set sets name in section. A value set again moves to the end of the section.
+func (c *Config) Set(section, name, value, source string) {
+	s := c.section(section)
+	if _, ok := s.items[name]; ok {
+		s.remove(name)
+	}
+	s.names = append(s.names, name)
+	s.items[name] = Item{Name: name, Value: value, Source: source}
+}
+
+// Unset removes name from section.
+func (c *Config) Unset(section, name string) {
+	if s, ok := c.sections[section]; ok {
+		if _, ok := s.items[name]; ok {
+			s.remove(name)
+		}
+	}
+}
+
+func (c *Config) section(name string) *section {
+	s, ok := c.sections[name]
+	if !ok {
+		s = &section{items: make(map[string]Item)}
+		c.sections[name] = s
+	}
+	return s
+}
+
+func (s *section) remove(name string) {
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+	delete(s.items, name)
+}
+
+// Mechanical translation of the regexps of mercurial/config.py.
+var (
+	sectionre = regexp.MustCompile(`^\[([^\[]+)\]`)
+	itemre    = regexp.MustCompile(`^([^=\s][^=]*?)\s*=\s*(.*\S|)`)
+	contre    = regexp.MustCompile(`^\s+(\S|\S.*\S)\s*$`)
+	emptyre   = regexp.MustCompile(`^(;|#|\s*$)`)
+	commentre = regexp.MustCompile(`^(;|#)`)
+	unsetre   = regexp.MustCompile(`^%unset\s+(\S+)`)
+	includere = regexp.MustCompile(`^%include\s+(\S|\S.*\S)\s*$`)
+)
+
+// ReadFile reads the configuration file at path into c.
+// A missing file is not an error.
+func (c *Config) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.Parse(path, data)
+}
+
+// Parse reads configuration data into c. src names the data in errors and item sources,
+// and relative %include paths are resolved against its directory.
+func (c *Config) Parse(src string, data []byte) error {
+	var section, item string
+	cont := false
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, l := range lines {
+		lineno := i + 1
+		l = strings.TrimRight(l, "\r\n")
+		if lineno == 1 {
+			l = strings.TrimPrefix(l, "\xef\xbb\xbf")
+		}
+
+		if cont {
+			if commentre.MatchString(l) {
+				continue
+			}
+			if m := contre.FindStringSubmatch(l); m != nil {
+				v, _ := c.Get(section, item)
+				c.Set(section, item, v+"\n"+m[1], fmt.Sprintf("%s:%d", src, lineno))
+				continue
+			}
+			item = ""
+			cont = false
+		}
+
+		if m := includere.FindStringSubmatch(l); m != nil {
+			inc := ExpandPath(m[1])
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(src), inc)
+			}
+			if err := c.ReadFile(inc); err != nil {
+				if _, ok := err.(*ParseError); ok {
+					return err
+				}
+				return &ParseError{Source: src, Line: lineno, Message: fmt.Sprintf("cannot include %s (%s)", inc, err)}
+			}
+			continue
+		}
+		if emptyre.MatchString(l) {
+			continue
+		}
+		if m := sectionre.FindStringSubmatch(l); m != nil {
+			section = m[1]
+			c.section(section)
+			continue
+		}
+		if m := itemre.FindStringSubmatch(l); m != nil {
+			item = m[1]
+			cont = true
+			c.Set(section, item, m[2], fmt.Sprintf("%s:%d", src, lineno))
+			continue
+		}
+		if m := unsetre.FindStringSubmatch(l); m != nil {
+			c.Unset(section, m[1])
+			continue
+		}
+
+		message := strings.TrimRight(l, " \t")
+		if strings.HasPrefix(l, " ") {
+			message = "unexpected leading whitespace: " + message
+		}
+		return &ParseError{Source: src, Line: lineno, Message: message}
+	}
+
+	return nil
+}
+
+// ExpandPath expands environment variables and a leading ~ in path, like util.expandpath.
+func ExpandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return path
+}
+
+// Paths returns the system-wide and per-user configuration files, in the order they are read.
+//
+// When HGRCPATH is set, it replaces them: it is a list of files and directories separated by
+// the OS path list separator, and directories contribute their *.rc files.
+func Paths() []string {
+	if hgrcpath, ok := os.LookupEnv("HGRCPATH"); ok {
+		var paths []string
+		for _, p := range filepath.SplitList(hgrcpath) {
+			if p == "" {
+				continue
+			}
+			paths = append(paths, rcFiles(ExpandPath(p))...)
+		}
+		return paths
+	}
+
+	paths := []string{"/etc/mercurial/hgrc"}
+	paths = append(paths, rcFiles("/etc/mercurial/hgrc.d")...)
+
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".hgrc"))
+		confighome := os.Getenv("XDG_CONFIG_HOME")
+		if !filepath.IsAbs(confighome) {
+			confighome = filepath.Join(home, ".config")
+		}
+		paths = append(paths, filepath.Join(confighome, "hg", "hgrc"))
+	}
+
+	return paths
+}
+
+// rcFiles returns path itself, or the sorted *.rc files of path when it is a directory.
+func rcFiles(path string) []string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if !info.IsDir() {
+		return []string{path}
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".rc") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
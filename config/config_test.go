@@ -0,0 +1,152 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c := New()
+	err := c.Parse("hgrc", []byte(`# comment
+[ui]
+username = Jane Doe <jane@example.com>
+ignore = ~/.hgignore
+; another comment
+ignore.work = .hgignore-work
+  continued
+
+[extensions]
+rebase =
+[ui]
+ignore = ~/.hgignore-global
+%unset ignore.work
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := c.Get("ui", "username"); !ok || v != "Jane Doe <jane@example.com>" {
+		t.Errorf("ui.username = %q, %v", v, ok)
+	}
+	if v, ok := c.Get("extensions", "rebase"); !ok || v != "" {
+		t.Errorf("extensions.rebase = %q, %v", v, ok)
+	}
+	if _, ok := c.Get("ui", "ignore.work"); ok {
+		t.Error("ui.ignore.work is still set after %unset")
+	}
+
+	var names []string
+	for _, item := range c.Items("ui") {
+		names = append(names, item.Name)
+	}
+	if want := []string{"username", "ignore"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Items(ui) = %v, want %v", names, want)
+	}
+	if item := c.Items("ui")[1]; item.Value != "~/.hgignore-global" || item.Source != "hgrc:12" {
+		t.Errorf("ui.ignore = %+v", item)
+	}
+}
+
+func TestParseContinuation(t *testing.T) {
+	c := New()
+	if err := c.Parse("hgrc", []byte("[a]\nb = one\n  two\n# comment\n  three\n")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("a", "b"); v != "one\ntwo\nthree" {
+		t.Errorf("a.b = %q", v)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	c := New()
+	err := c.Parse("hgrc", []byte("[ui]\n  leading\n"))
+	if err == nil {
+		t.Fatal("Parse() succeeded on leading whitespace")
+	}
+	if want := "parse error at hgrc:2: unexpected leading whitespace:   leading"; err.Error() != want {
+		t.Errorf("Parse() error = %q, want %q", err, want)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main"), []byte("%include other\n[ui]\nb = main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other"), []byte("[ui]\na = other\nb = other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	if err := c.ReadFile(filepath.Join(dir, "main")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("ui", "a"); v != "other" {
+		t.Errorf("ui.a = %q, want other", v)
+	}
+	if v, _ := c.Get("ui", "b"); v != "main" {
+		t.Errorf("ui.b = %q, want main", v)
+	}
+}
+
+func TestPathsHGRCPATH(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.rc", "a.rc", "c.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "c.txt")
+
+	old, set := os.LookupEnv("HGRCPATH")
+	os.Setenv("HGRCPATH", dir+string(filepath.ListSeparator)+file)
+	defer func() {
+		if set {
+			os.Setenv("HGRCPATH", old)
+		} else {
+			os.Unsetenv("HGRCPATH")
+		}
+	}()
+
+	want := []string{filepath.Join(dir, "a.rc"), filepath.Join(dir, "b.rc"), file}
+	if got := Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths() = %v, want %v", got, want)
+	}
+}
+
+func TestBool(t *testing.T) {
+	c := New()
+	c.Set("ui", "yes", "Yes", "")
+	c.Set("ui", "off", "off", "")
+	c.Set("ui", "bad", "maybe", "")
+
+	tests := []struct {
+		name      string
+		def, want bool
+		ok        bool
+	}{
+		{"yes", false, true, true},
+		{"off", true, false, true},
+		{"unset", true, true, true},
+		{"bad", false, false, false},
+	}
+	for _, tt := range tests {
+		got, err := c.Bool("ui", tt.name, tt.def)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("Bool(ui, %s, %v) = %v, %v, want %v", tt.name, tt.def, got, err, tt.want)
+		}
+	}
+}
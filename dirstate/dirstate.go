@@ -0,0 +1,340 @@
+// Package dirstate reads and writes the Mercurial dirstate file.
+//
+// Original Hg wiki page on DirState: https://www.mercurial-scm.org/wiki/DirState
+package dirstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	radix "github.com/armon/go-radix"
+)
+
+// https://www.mercurial-scm.org/wiki/DirState
+
+// 3.7. dirstate
+
+// This file contains information on the current state of the working directory in a binary format.
+// It begins with two 20-byte hashes, for first and second parent, followed by an entry for each file.
+//
+// Each file entry is of the following form:
+// 	<1-byte state><4-byte mode><4-byte size><4-byte mtime><4-byte name length><n-byte name>
+//
+// If the name contains a null character, it is split into two strings,
+// with the second being the copy source for move and copy operations.
+//
+// If the dirstate file is not present, parents are assumed to be (null, null) with no files tracked.
+//
+// Source: mercurial/parsers.py:parse_dirstate()
+
+// Node is a 20-byte changeset hash.
+type Node [20]byte
+
+// NullNode is the hash of the null revision.
+var NullNode Node
+
+func (n Node) String() string {
+	return hex.EncodeToString(n[:])
+}
+
+// FileState is used to quickly determine what files in the working directory have changed.
+type FileState byte
+
+// The states that are tracked are:
+//   - n - normal
+//   - a - added
+//   - r - removed
+//   - m - 3-way merged
+const (
+	Normal  FileState = 'n'
+	Added   FileState = 'a'
+	Removed FileState = 'r'
+	Merged  FileState = 'm'
+)
+
+func (s FileState) String() string {
+	return string(s)
+}
+
+// Special values stored in the size and mtime fields.
+const (
+	// SizeNonNormal marks an entry whose size must not be trusted.
+	SizeNonNormal = -1
+	// SizeFromP2 marks an entry that comes from the second parent of a merge.
+	SizeFromP2 = -2
+	// MtimeUnset marks an entry whose mtime must not be trusted.
+	MtimeUnset = -1
+
+	// rangeMask is applied to sizes and mtimes before they are stored.
+	rangeMask = 0x7fffffff
+)
+
+const (
+	parentsSize = 2 * len(NullNode)
+	headerSize  = 17
+)
+
+// Entry is a single file record of the dirstate.
+type Entry struct {
+	Name       string
+	State      FileState
+	Mode       uint32
+	Size       int32
+	Mtime      int32
+	CopySource string
+
+	// MtimeNsec is the sub-second part of Mtime. Only dirstate-v2 stores it.
+	MtimeNsec int32
+
+	// v2Flags keeps dirstate-v2 flags the v1 view has no room for.
+	v2Flags uint16
+}
+
+// DirState is the parsed content of a dirstate file.
+// Entries are kept in the on-disk order so that Write reproduces the file exactly.
+type DirState struct {
+	Parents [2]Node
+	Entries []*Entry
+
+	// Version is the format the dirstate was read from: 1 or 2 (dirstate-v2).
+	Version int
+
+	// DirMtimes and IgnoreHash are only stored by dirstate-v2.
+	DirMtimes  map[string]DirMtime
+	IgnoreHash [20]byte
+
+	// uid names the dirstate-v2 data file the dirstate was read from.
+	uid string
+	// lossy is set when the dirstate-v2 data holds node data that writing
+	// the entries back would not reproduce.
+	lossy bool
+}
+
+var (
+	// ErrTruncated is returned when the dirstate ends in the middle of a record.
+	ErrTruncated = errors.New("truncated dirstate")
+	// ErrCorrupt is returned when the dirstate contains an invalid record.
+	ErrCorrupt = errors.New("corrupt dirstate")
+)
+
+// ParseError records the offset at which a dirstate failed to parse.
+type ParseError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Err, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse reads a dirstate from r.
+func Parse(r io.Reader) (*DirState, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &DirState{Version: 1}
+
+	// An empty dirstate is the same as a missing one.
+	if len(data) == 0 {
+		return ds, nil
+	}
+	if len(data) < parentsSize {
+		return nil, &ParseError{Offset: 0, Err: ErrTruncated}
+	}
+	copy(ds.Parents[0][:], data[0:20])
+	copy(ds.Parents[1][:], data[20:40])
+
+	offset := parentsSize
+	for offset < len(data) {
+		if len(data)-offset < headerSize {
+			return nil, &ParseError{Offset: int64(offset), Err: ErrTruncated}
+		}
+		h := data[offset : offset+headerSize]
+		e := &Entry{
+			State: FileState(h[0]),
+			Mode:  binary.BigEndian.Uint32(h[1:5]),
+			Size:  int32(binary.BigEndian.Uint32(h[5:9])),
+			Mtime: int32(binary.BigEndian.Uint32(h[9:13])),
+		}
+		switch e.State {
+		case Normal, Added, Removed, Merged:
+		default:
+			return nil, &ParseError{Offset: int64(offset), Err: ErrCorrupt}
+		}
+
+		namelen := int(binary.BigEndian.Uint32(h[13:17]))
+		offset += headerSize
+		if namelen < 0 || len(data)-offset < namelen {
+			return nil, &ParseError{Offset: int64(offset), Err: ErrTruncated}
+		}
+		name := data[offset : offset+namelen]
+		offset += namelen
+
+		// If the name contains a null character, it is split into two strings,
+		// with the second being the copy source for move and copy operations.
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			e.CopySource = string(name[i+1:])
+			name = name[:i]
+		}
+		if len(name) == 0 {
+			return nil, &ParseError{Offset: int64(offset - namelen), Err: ErrCorrupt}
+		}
+		e.Name = string(name)
+
+		ds.Entries = append(ds.Entries, e)
+	}
+
+	return ds, nil
+}
+
+// ReadFile parses the dirstate file at path.
+// A missing file yields null parents and no entries.
+func ReadFile(path string) (*DirState, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &DirState{Version: 1}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ds, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ds, nil
+}
+
+// Write serializes the dirstate to w in the same layout Parse reads.
+func (d *DirState) Write(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.Write(d.Parents[0][:])
+	buf.Write(d.Parents[1][:])
+
+	header := make([]byte, headerSize)
+	for _, e := range d.Entries {
+		name := e.Name
+		if e.CopySource != "" {
+			name += "\x00" + e.CopySource
+		}
+
+		header[0] = byte(e.State)
+		binary.BigEndian.PutUint32(header[1:5], e.Mode)
+		binary.BigEndian.PutUint32(header[5:9], uint32(e.Size))
+		binary.BigEndian.PutUint32(header[9:13], uint32(e.Mtime))
+		binary.BigEndian.PutUint32(header[13:17], uint32(len(name)))
+		buf.Write(header)
+		buf.WriteString(name)
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteFile atomically replaces the dirstate file at path.
+func WriteFile(path string, d *DirState) error {
+	var buf bytes.Buffer
+	if err := d.Write(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Tree returns the entries indexed by file name.
+func (d *DirState) Tree() *radix.Tree {
+	t := radix.New()
+	for _, e := range d.Entries {
+		t.Insert(e.Name, e)
+	}
+	return t
+}
+
+// Copies returns the copy sources indexed by destination file name.
+func (d *DirState) Copies() *radix.Tree {
+	t := radix.New()
+	for _, e := range d.Entries {
+		if e.CopySource != "" {
+			t.Insert(e.Name, e.CopySource)
+		}
+	}
+	return t
+}
+
+// MtimeEqual reports whether t is the mtime recorded in e. Seconds are compared
+// modulo 2^31, and nanoseconds too when both sides know them.
+func (e *Entry) MtimeEqual(t time.Time) bool {
+	if int64(e.Mtime) != t.Unix()&rangeMask {
+		return false
+	}
+	return e.MtimeNsec == 0 || t.Nanosecond() == 0 || int(e.MtimeNsec) == t.Nanosecond()
+}
+
+// MtimeAmbiguous reports whether the mtime recorded in e can't be trusted to
+// change with the file because it is not older than t, the time the dirstate
+// was written: the file may have been changed again within the same clock tick.
+// Seconds are compared, and nanoseconds too when both sides know them.
+//
+// This is the opposite of TruncatedTimestamp::is_reliable_mtime in Mercurial.
+func (e *Entry) MtimeAmbiguous(t time.Time) bool {
+	if e.Mtime == MtimeUnset {
+		return false
+	}
+	sec := t.Unix() & rangeMask
+	if int64(e.Mtime) != sec {
+		return int64(e.Mtime) > sec
+	}
+	if e.MtimeNsec == 0 || t.Nanosecond() == 0 {
+		return true
+	}
+	return int(e.MtimeNsec) >= t.Nanosecond()
+}
+
+// ClearAmbiguousMtimes unsets the mtime of normal entries that are ambiguous at now,
+// the time the dirstate is being written, so that the files are compared by content
+// the next time instead of being taken for clean.
+func (d *DirState) ClearAmbiguousMtimes(now time.Time) {
+	for _, e := range d.Entries {
+		if e.State == Normal && e.MtimeAmbiguous(now) {
+			e.Mtime = MtimeUnset
+			e.MtimeNsec = 0
+		}
+	}
+}
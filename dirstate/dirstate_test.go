@@ -0,0 +1,178 @@
+package dirstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func entryBytes(state byte, mode uint32, size, mtime int32, name string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(state)
+	binary.Write(&buf, binary.BigEndian, mode)
+	binary.Write(&buf, binary.BigEndian, size)
+	binary.Write(&buf, binary.BigEndian, mtime)
+	binary.Write(&buf, binary.BigEndian, uint32(len(name)))
+	buf.WriteString(name)
+	return buf.Bytes()
+}
+
+func sampleDirState() []byte {
+	var buf bytes.Buffer
+	buf.Write(bytes.Repeat([]byte{0xab}, 20))
+	buf.Write(make([]byte, 20))
+	buf.Write(entryBytes('n', 0100644, 12, 1500000000, "b.go"))
+	buf.Write(entryBytes('a', 0, -1, -1, "a/copy.go\x00b.go"))
+	buf.Write(entryBytes('r', 0, 0, 0, "gone.txt"))
+	buf.Write(entryBytes('m', 0100755, -2, -1, "run.sh"))
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	ds, err := Parse(bytes.NewReader(sampleDirState()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.Parents[0].String() != "abababababababababababababababababababab" || ds.Parents[1] != NullNode {
+		t.Errorf("Parents = %s, %s", ds.Parents[0], ds.Parents[1])
+	}
+
+	want := []Entry{
+		{Name: "b.go", State: Normal, Mode: 0100644, Size: 12, Mtime: 1500000000},
+		{Name: "a/copy.go", State: Added, Size: SizeNonNormal, Mtime: MtimeUnset, CopySource: "b.go"},
+		{Name: "gone.txt", State: Removed},
+		{Name: "run.sh", State: Merged, Mode: 0100755, Size: SizeFromP2, Mtime: MtimeUnset},
+	}
+	if len(ds.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(ds.Entries), len(want))
+	}
+	for i := range want {
+		if *ds.Entries[i] != want[i] {
+			t.Errorf("Entries[%d] = %+v, want %+v", i, *ds.Entries[i], want[i])
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	data := sampleDirState()
+	ds, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := ds.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Write() output differs from the parsed input:\n got %q\nwant %q", out.Bytes(), data)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	data := sampleDirState()
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "short parents", data: data[:30], err: ErrTruncated},
+		{name: "short header", data: data[:45], err: ErrTruncated},
+		{name: "short name", data: data[:40+17+2], err: ErrTruncated},
+		{name: "bad state", data: append(data[:40:40], entryBytes('x', 0, 0, 0, "f")...), err: ErrCorrupt},
+		{name: "empty name", data: append(data[:40:40], entryBytes('n', 0, 0, 0, "")...), err: ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(bytes.NewReader(tt.data))
+		var perr *ParseError
+		if !errors.Is(err, tt.err) || !errors.As(err, &perr) {
+			t.Errorf("%s: Parse() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestReadFileMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirstate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ds, err := ReadFile(filepath.Join(dir, "dirstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Parents[0] != NullNode || ds.Parents[1] != NullNode || len(ds.Entries) != 0 {
+		t.Errorf("ReadFile(missing) = %+v, want null parents and no entries", ds)
+	}
+}
+
+func TestMtimeEqual(t *testing.T) {
+	tests := []struct {
+		mtime, nsec int32
+		t           time.Time
+		want        bool
+	}{
+		{1500000000, 0, time.Unix(1500000000, 123), true},
+		{1500000000, 123, time.Unix(1500000000, 123), true},
+		{1500000000, 123, time.Unix(1500000000, 0), true},
+		{1500000000, 123, time.Unix(1500000000, 456), false},
+		{1500000000, 0, time.Unix(1500000001, 0), false},
+		{0, 0, time.Unix(1<<31, 0), true},
+	}
+
+	for _, tt := range tests {
+		e := &Entry{State: Normal, Mtime: tt.mtime, MtimeNsec: tt.nsec}
+		if got := e.MtimeEqual(tt.t); got != tt.want {
+			t.Errorf("MtimeEqual(%d.%09d, %v) = %v, want %v", tt.mtime, tt.nsec, tt.t.UnixNano(), got, tt.want)
+		}
+	}
+}
+
+func TestMtimeAmbiguous(t *testing.T) {
+	written := time.Unix(1500000000, 500)
+	tests := []struct {
+		mtime, nsec int32
+		want        bool
+	}{
+		{1499999999, 0, false},
+		{1500000000, 0, true},
+		{1500000000, 400, false},
+		{1500000000, 500, true},
+		{1500000001, 0, true},
+		{MtimeUnset, 0, false},
+	}
+
+	for _, tt := range tests {
+		e := &Entry{State: Normal, Mtime: tt.mtime, MtimeNsec: tt.nsec}
+		if got := e.MtimeAmbiguous(written); got != tt.want {
+			t.Errorf("MtimeAmbiguous(%d.%09d) = %v, want %v", tt.mtime, tt.nsec, got, tt.want)
+		}
+	}
+}
+
+func TestClearAmbiguousMtimes(t *testing.T) {
+	ds, err := Parse(bytes.NewReader(sampleDirState()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.ClearAmbiguousMtimes(time.Unix(1500000000, 0))
+	if e := ds.Entries[0]; e.Mtime != MtimeUnset || e.Size != 12 {
+		t.Errorf("ambiguous entry %s has mtime %d, size %d", e.Name, e.Mtime, e.Size)
+	}
+
+	ds.Entries[0].Mtime = 1400000000
+	ds.ClearAmbiguousMtimes(time.Unix(1500000000, 0))
+	if e := ds.Entries[0]; e.Mtime != 1400000000 {
+		t.Errorf("entry %s lost its mtime", e.Name)
+	}
+}
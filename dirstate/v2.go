@@ -0,0 +1,606 @@
+package dirstate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirstate-v2
+//
+// In repositories with the "dirstate-v2" requirement .hg/dirstate is a small docket file:
+//
+// 	<12-byte marker "dirstate-v2\n"><32-byte p1><32-byte p2><44-byte tree metadata>
+// 	<4-byte data size><1-byte uid length><uid>
+//
+// Parent hashes are padded with zeros to 32 bytes.
+// The tree itself lives in the .hg/dirstate.<uid> data file, only the first "data size" bytes of which are meaningful.
+//
+// Tree metadata:
+// 	<4-byte root nodes start><4-byte root nodes count><4-byte nodes with entry count>
+// 	<4-byte nodes with copy source count><4-byte unreachable bytes><4 unused bytes><20-byte ignore patterns hash>
+//
+// Every node of the tree is 44 bytes long:
+// 	<4-byte full path start><2-byte full path length><2-byte base name start>
+// 	<4-byte copy source start><2-byte copy source length>
+// 	<4-byte children start><4-byte children count>
+// 	<4-byte descendants with entry count><4-byte tracked descendants count>
+// 	<2-byte flags><4-byte size><4-byte mtime seconds><4-byte mtime nanoseconds>
+//
+// All integers are big-endian. A zero copy source start means there is no copy source.
+//
+// Source: mercurial/helptext/internals/dirstate-v2.txt, mercurial/dirstateutils/v2.py
+
+// V2Marker starts every dirstate-v2 docket.
+const V2Marker = "dirstate-v2\n"
+
+const (
+	storedNodeSize   = 32
+	treeMetadataSize = 44
+	docketHeaderSize = len(V2Marker) + 2*storedNodeSize + treeMetadataSize + 4 + 1
+	nodeSize         = 44
+)
+
+// Node flags of the dirstate-v2 format.
+const (
+	flagWdirTracked             = 1 << 0
+	flagP1Tracked               = 1 << 1
+	flagP2Info                  = 1 << 2
+	flagModeExecPerm            = 1 << 3
+	flagModeIsSymlink           = 1 << 4
+	flagHasFallbackExec         = 1 << 5
+	flagFallbackExec            = 1 << 6
+	flagHasFallbackSymlink      = 1 << 7
+	flagFallbackSymlink         = 1 << 8
+	flagExpectedStateIsModified = 1 << 9
+	flagHasModeAndSize          = 1 << 10
+	flagHasMtime                = 1 << 11
+	flagMtimeSecondAmbiguous    = 1 << 12
+	flagDirectory               = 1 << 13
+	flagAllUnknownRecorded      = 1 << 14
+	flagAllIgnoredRecorded      = 1 << 15
+
+	// Flags carried over unchanged between reads and writes.
+	flagsPreserved = flagHasFallbackExec | flagFallbackExec | flagHasFallbackSymlink | flagFallbackSymlink
+)
+
+// File type and permission bits of the mode field.
+const (
+	modeRegular = 0100000
+	modeSymlink = 0120000
+	modeExec    = 0111
+)
+
+// DirMtime is a directory mtime cached by the dirstate-v2 format.
+type DirMtime struct {
+	Sec  int32
+	Nsec int32
+	// AllUnknownRecorded and AllIgnoredRecorded tell whether the direct children
+	// of the directory were recorded in the tree when the mtime was cached.
+	AllUnknownRecorded bool
+	AllIgnoredRecorded bool
+}
+
+// Docket is the content of a dirstate-v2 .hg/dirstate file.
+type Docket struct {
+	Parents    [2]Node
+	DataSize   uint32
+	UID        string
+	IgnoreHash [20]byte
+
+	rootStart, rootCount uint32
+}
+
+// DataFilename returns the name of the data file the docket points at.
+func (d *Docket) DataFilename() string {
+	return "dirstate." + d.UID
+}
+
+// ParseDocket parses a dirstate-v2 docket.
+func ParseDocket(data []byte) (*Docket, error) {
+	if !bytes.HasPrefix(data, []byte(V2Marker)) {
+		if len(data) < len(V2Marker) {
+			return nil, &ParseError{Offset: 0, Err: ErrTruncated}
+		}
+		return nil, &ParseError{Offset: 0, Err: ErrCorrupt}
+	}
+	if len(data) < docketHeaderSize {
+		return nil, &ParseError{Offset: int64(len(data)), Err: ErrTruncated}
+	}
+
+	d := &Docket{}
+	off := len(V2Marker)
+	copy(d.Parents[0][:], data[off:off+len(NullNode)])
+	off += storedNodeSize
+	copy(d.Parents[1][:], data[off:off+len(NullNode)])
+	off += storedNodeSize
+
+	meta := data[off : off+treeMetadataSize]
+	d.rootStart = binary.BigEndian.Uint32(meta[0:4])
+	d.rootCount = binary.BigEndian.Uint32(meta[4:8])
+	copy(d.IgnoreHash[:], meta[24:44])
+	off += treeMetadataSize
+
+	d.DataSize = binary.BigEndian.Uint32(data[off : off+4])
+	uidlen := int(data[off+4])
+	off += 5
+	if len(data)-off < uidlen {
+		return nil, &ParseError{Offset: int64(off), Err: ErrTruncated}
+	}
+	d.UID = string(data[off : off+uidlen])
+	if d.UID == "" || strings.ContainsAny(d.UID, "/\\\x00") {
+		return nil, &ParseError{Offset: int64(off), Err: ErrCorrupt}
+	}
+
+	return d, nil
+}
+
+// ParseV2 builds a dirstate from a docket and the content of its data file.
+func ParseV2(docket *Docket, data []byte) (*DirState, error) {
+	if uint32(len(data)) < docket.DataSize {
+		return nil, &ParseError{Offset: int64(len(data)), Err: ErrTruncated}
+	}
+	data = data[:docket.DataSize]
+
+	ds := &DirState{
+		Parents:    docket.Parents,
+		Version:    2,
+		IgnoreHash: docket.IgnoreHash,
+		uid:        docket.UID,
+	}
+	if err := ds.parseNodes(data, docket.rootStart, docket.rootCount, 0); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// slice returns data[start:start+length] or a ParseError pointing at the node being read.
+func slice(data []byte, start, length uint32, at uint32) ([]byte, error) {
+	end := uint64(start) + uint64(length)
+	if end > uint64(len(data)) {
+		return nil, &ParseError{Offset: int64(at), Err: ErrTruncated}
+	}
+	return data[start:end], nil
+}
+
+// maxDepth bounds the recursion on corrupt trees with cycles.
+const maxDepth = 4096
+
+func (d *DirState) parseNodes(data []byte, start, count uint32, depth int) error {
+	if depth > maxDepth {
+		return &ParseError{Offset: int64(start), Err: ErrCorrupt}
+	}
+	if uint64(start)+uint64(count)*nodeSize > uint64(len(data)) {
+		return &ParseError{Offset: int64(start), Err: ErrTruncated}
+	}
+	nodes := data[start : start+count*nodeSize]
+
+	for i := uint32(0); i < count; i++ {
+		at := start + i*nodeSize
+		n := nodes[i*nodeSize : (i+1)*nodeSize]
+
+		pathStart := binary.BigEndian.Uint32(n[0:4])
+		pathLen := uint32(binary.BigEndian.Uint16(n[4:6]))
+		copyStart := binary.BigEndian.Uint32(n[8:12])
+		copyLen := uint32(binary.BigEndian.Uint16(n[12:14]))
+		childrenStart := binary.BigEndian.Uint32(n[14:18])
+		childrenCount := binary.BigEndian.Uint32(n[18:22])
+		flags := binary.BigEndian.Uint16(n[30:32])
+		size := binary.BigEndian.Uint32(n[32:36])
+		mtimeSec := int32(binary.BigEndian.Uint32(n[36:40]))
+		mtimeNsec := int32(binary.BigEndian.Uint32(n[40:44]))
+
+		if err := d.parseNodes(data, childrenStart, childrenCount, depth+1); err != nil {
+			return err
+		}
+
+		path, err := slice(data, pathStart, pathLen, at)
+		if err != nil {
+			return err
+		}
+
+		if flags&(flagWdirTracked|flagP1Tracked|flagP2Info) == 0 {
+			known := uint16(flagDirectory)
+			if flags&flagDirectory != 0 && flags&flagHasMtime != 0 {
+				known |= flagHasMtime | flagAllUnknownRecorded | flagAllIgnoredRecorded
+			}
+			if flags&^known != 0 {
+				d.lossy = true
+			}
+			if flags&flagDirectory != 0 && flags&flagHasMtime != 0 {
+				if d.DirMtimes == nil {
+					d.DirMtimes = make(map[string]DirMtime)
+				}
+				d.DirMtimes[string(path)] = DirMtime{
+					Sec:                mtimeSec,
+					Nsec:               mtimeNsec,
+					AllUnknownRecorded: flags&flagAllUnknownRecorded != 0,
+					AllIgnoredRecorded: flags&flagAllIgnoredRecorded != 0,
+				}
+			}
+			continue
+		}
+
+		e := entryFromV2(flags, size, mtimeSec, mtimeNsec)
+		if f, s, sec, nsec := e.v2Data(); f != flags || s != size || sec != mtimeSec || nsec != mtimeNsec {
+			d.lossy = true
+		}
+		e.Name = string(path)
+		if copyStart != 0 {
+			source, err := slice(data, copyStart, copyLen, at)
+			if err != nil {
+				return err
+			}
+			e.CopySource = string(source)
+		}
+		d.Entries = append(d.Entries, e)
+	}
+
+	return nil
+}
+
+// Lossless reports whether writing the dirstate keeps all the data it was read from.
+// It is false when some dirstate-v2 node holds data the entries have no room for,
+// such as the expected-state-is-modified flag or an ambiguous mtime.
+func (d *DirState) Lossless() bool {
+	return !d.lossy
+}
+
+// entryFromV2 converts dirstate-v2 node data to the v1 view of an entry.
+// Each state gets the mode, size and mtime Mercurial writes for it in a v1
+// dirstate, so that both formats read the same working copy into equal entries:
+// mtime 0 for removed files, MtimeUnset for the other entries that aren't clean.
+//
+// Source: mercurial/pure/parsers.py:DirstateItem.v1_mode, v1_size and v1_mtime
+func entryFromV2(flags uint16, size uint32, mtimeSec, mtimeNsec int32) *Entry {
+	wc := flags&flagWdirTracked != 0
+	p1 := flags&flagP1Tracked != 0
+	p2 := flags&flagP2Info != 0
+
+	e := &Entry{v2Flags: flags & flagsPreserved}
+
+	if flags&flagHasModeAndSize != 0 {
+		e.Mode = 0644
+		if flags&flagModeExecPerm != 0 {
+			e.Mode = 0755
+		}
+		if flags&flagModeIsSymlink != 0 {
+			e.Mode |= modeSymlink
+		} else {
+			e.Mode |= modeRegular
+		}
+	}
+
+	switch {
+	case !wc:
+		e.State = Removed
+		e.Mtime = 0
+		switch {
+		case p1 && p2:
+			e.Size = SizeNonNormal
+		case p2:
+			e.Size = SizeFromP2
+		default:
+			e.Size = 0
+		}
+	case p1 && p2:
+		e.State = Merged
+		e.Size = SizeFromP2
+		e.Mtime = MtimeUnset
+	case !p1 && !p2:
+		e.State = Added
+		e.Size = SizeNonNormal
+		e.Mtime = MtimeUnset
+	case p2:
+		e.State = Normal
+		e.Size = SizeFromP2
+		e.Mtime = MtimeUnset
+	default:
+		e.State = Normal
+		e.Size = SizeNonNormal
+		if flags&flagHasModeAndSize != 0 {
+			e.Size = int32(size & rangeMask)
+		}
+		e.Mtime = MtimeUnset
+		if flags&flagHasMtime != 0 && flags&flagMtimeSecondAmbiguous == 0 && flags&flagExpectedStateIsModified == 0 {
+			e.Mtime = mtimeSec
+			e.MtimeNsec = mtimeNsec
+		}
+	}
+
+	return e
+}
+
+// v2Data converts an entry back to dirstate-v2 node data.
+func (e *Entry) v2Data() (flags uint16, size uint32, mtimeSec, mtimeNsec int32) {
+	flags = e.v2Flags & flagsPreserved
+
+	switch e.State {
+	case Merged:
+		flags |= flagWdirTracked | flagP1Tracked | flagP2Info
+	case Added:
+		flags |= flagWdirTracked
+	case Removed:
+		switch e.Size {
+		case SizeNonNormal:
+			flags |= flagP1Tracked | flagP2Info
+		case SizeFromP2:
+			flags |= flagP2Info
+		default:
+			flags |= flagP1Tracked
+		}
+	case Normal:
+		switch e.Size {
+		case SizeFromP2:
+			flags |= flagWdirTracked | flagP2Info
+		case SizeNonNormal:
+			flags |= flagWdirTracked | flagP1Tracked
+		default:
+			flags |= flagWdirTracked | flagP1Tracked | flagHasModeAndSize
+			if e.Mode&modeExec != 0 {
+				flags |= flagModeExecPerm
+			}
+			if e.Mode&0170000 == modeSymlink {
+				flags |= flagModeIsSymlink
+			}
+			size = uint32(e.Size)
+			if e.Mtime != MtimeUnset {
+				flags |= flagHasMtime
+				mtimeSec = e.Mtime
+				mtimeNsec = e.MtimeNsec
+			}
+		}
+	}
+
+	return flags, size, mtimeSec, mtimeNsec
+}
+
+// treeNode is a node of the in-memory tree built when writing dirstate-v2 data.
+type treeNode struct {
+	path     string
+	base     int
+	entry    *Entry
+	dir      *DirMtime
+	children map[string]*treeNode
+
+	withEntry int
+	tracked   int
+}
+
+func (n *treeNode) child(path string, base int) *treeNode {
+	name := path[base:]
+	c, ok := n.children[name]
+	if !ok {
+		c = &treeNode{path: path, base: base, children: make(map[string]*treeNode)}
+		n.children[name] = c
+	}
+	return c
+}
+
+// node returns the tree node for path, creating missing ancestors on the way.
+func (n *treeNode) node(path string) *treeNode {
+	cur := n
+	base := 0
+	for {
+		i := strings.IndexByte(path[base:], '/')
+		if i < 0 {
+			return cur.child(path, base)
+		}
+		cur = cur.child(path[:base+i], base)
+		base += i + 1
+	}
+}
+
+// count fills in the descendant counters and returns them for n itself included.
+func (n *treeNode) count() (withEntry, tracked int) {
+	for _, c := range n.children {
+		w, t := c.count()
+		n.withEntry += w
+		n.tracked += t
+	}
+	withEntry, tracked = n.withEntry, n.tracked
+	if n.entry != nil {
+		withEntry++
+		if n.entry.State != Removed {
+			tracked++
+		}
+	}
+	return withEntry, tracked
+}
+
+func (n *treeNode) sortedChildren() []*treeNode {
+	children := make([]*treeNode, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].path[children[i].base:] < children[j].path[children[j].base:]
+	})
+	return children
+}
+
+// writeNodes appends the children of n (and recursively their children) to buf
+// and returns the position of the children array.
+func (n *treeNode) writeNodes(buf *bytes.Buffer) (start, count uint32) {
+	children := n.sortedChildren()
+	if len(children) == 0 {
+		return 0, 0
+	}
+
+	type offsets struct {
+		childrenStart, childrenCount uint32
+		pathStart, copyStart         uint32
+	}
+	offs := make([]offsets, len(children))
+	for i, c := range children {
+		offs[i].childrenStart, offs[i].childrenCount = c.writeNodes(buf)
+	}
+	for i, c := range children {
+		offs[i].pathStart = uint32(buf.Len())
+		buf.WriteString(c.path)
+		if c.entry != nil && c.entry.CopySource != "" {
+			offs[i].copyStart = uint32(buf.Len())
+			buf.WriteString(c.entry.CopySource)
+		}
+	}
+
+	start = uint32(buf.Len())
+	node := make([]byte, nodeSize)
+	for i, c := range children {
+		for j := range node {
+			node[j] = 0
+		}
+		binary.BigEndian.PutUint32(node[0:4], offs[i].pathStart)
+		binary.BigEndian.PutUint16(node[4:6], uint16(len(c.path)))
+		binary.BigEndian.PutUint16(node[6:8], uint16(c.base))
+		if offs[i].copyStart != 0 {
+			binary.BigEndian.PutUint32(node[8:12], offs[i].copyStart)
+			binary.BigEndian.PutUint16(node[12:14], uint16(len(c.entry.CopySource)))
+		}
+		binary.BigEndian.PutUint32(node[14:18], offs[i].childrenStart)
+		binary.BigEndian.PutUint32(node[18:22], offs[i].childrenCount)
+		binary.BigEndian.PutUint32(node[22:26], uint32(c.withEntry))
+		binary.BigEndian.PutUint32(node[26:30], uint32(c.tracked))
+
+		var flags uint16
+		var size uint32
+		var mtimeSec, mtimeNsec int32
+		if c.entry != nil {
+			flags, size, mtimeSec, mtimeNsec = c.entry.v2Data()
+		} else if c.dir != nil {
+			flags = flagDirectory | flagHasMtime
+			if c.dir.AllUnknownRecorded {
+				flags |= flagAllUnknownRecorded
+			}
+			if c.dir.AllIgnoredRecorded {
+				flags |= flagAllIgnoredRecorded
+			}
+			mtimeSec, mtimeNsec = c.dir.Sec, c.dir.Nsec
+		}
+		binary.BigEndian.PutUint16(node[30:32], flags)
+		binary.BigEndian.PutUint32(node[32:36], size)
+		binary.BigEndian.PutUint32(node[36:40], uint32(mtimeSec))
+		binary.BigEndian.PutUint32(node[40:44], uint32(mtimeNsec))
+		buf.Write(node)
+	}
+
+	return start, uint32(len(children))
+}
+
+// MarshalV2 serializes the dirstate in the dirstate-v2 format.
+// It returns the docket and the data file it refers to; uid names the data file.
+func (d *DirState) MarshalV2(uid string) (docket, data []byte) {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	copies := 0
+	for _, e := range d.Entries {
+		root.node(e.Name).entry = e
+		if e.CopySource != "" {
+			copies++
+		}
+	}
+	for path, m := range d.DirMtimes {
+		m := m
+		n := root.node(path)
+		if n.entry == nil {
+			n.dir = &m
+		}
+	}
+	root.count()
+
+	var buf bytes.Buffer
+	rootStart, rootCount := root.writeNodes(&buf)
+	data = buf.Bytes()
+
+	var out bytes.Buffer
+	out.WriteString(V2Marker)
+	parent := make([]byte, storedNodeSize)
+	for _, p := range d.Parents {
+		copy(parent, p[:])
+		out.Write(parent)
+	}
+	meta := make([]byte, treeMetadataSize)
+	binary.BigEndian.PutUint32(meta[0:4], rootStart)
+	binary.BigEndian.PutUint32(meta[4:8], rootCount)
+	binary.BigEndian.PutUint32(meta[8:12], uint32(len(d.Entries)))
+	binary.BigEndian.PutUint32(meta[12:16], uint32(copies))
+	copy(meta[24:44], d.IgnoreHash[:])
+	out.Write(meta)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(data)))
+	out.Write(size)
+	out.WriteByte(byte(len(uid)))
+	out.WriteString(uid)
+
+	return out.Bytes(), data
+}
+
+// newUID returns a random identifier for a dirstate-v2 data file.
+func newUID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ReadFileV2 parses the dirstate-v2 docket at path and the data file next to it.
+// A missing docket yields null parents and no entries.
+func ReadFileV2(path string) (*DirState, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &DirState{Version: 2}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	docket, err := ParseDocket(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dataPath := filepath.Join(filepath.Dir(path), docket.DataFilename())
+	data, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := ParseV2(docket, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dataPath, err)
+	}
+	return ds, nil
+}
+
+// WriteFileV2 writes the dirstate as a new dirstate-v2 data file and points
+// the docket at path to it. The previous data file is removed.
+func WriteFileV2(path string, d *DirState) error {
+	uid, err := newUID()
+	if err != nil {
+		return err
+	}
+	docket, data := d.MarshalV2(uid)
+
+	dir := filepath.Dir(path)
+	dataPath := filepath.Join(dir, "dirstate."+uid)
+	if err := ioutil.WriteFile(dataPath, data, 0644); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, docket); err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+
+	if d.uid != "" && d.uid != uid {
+		os.Remove(filepath.Join(dir, "dirstate."+d.uid))
+	}
+	d.uid = uid
+	return nil
+}
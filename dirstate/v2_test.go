@@ -0,0 +1,246 @@
+package dirstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// v2Node packs a single dirstate-v2 tree node.
+func v2Node(pathStart uint32, pathLen, baseStart uint16, copyStart uint32, copyLen uint16,
+	childrenStart, childrenCount, withEntry, tracked uint32, flags uint16, size uint32, sec, nsec int32) []byte {
+	var buf bytes.Buffer
+	for _, v := range []interface{}{pathStart, pathLen, baseStart, copyStart, copyLen,
+		childrenStart, childrenCount, withEntry, tracked, flags, size, sec, nsec} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func v2Docket(p1 Node, rootStart, rootCount, entries, copies uint32, dataSize uint32, uid string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(V2Marker)
+	buf.Write(p1[:])
+	buf.Write(make([]byte, 12+32))
+	for _, v := range []uint32{rootStart, rootCount, entries, copies, 0, 0} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.Write(make([]byte, 20))
+	binary.Write(&buf, binary.BigEndian, dataSize)
+	buf.WriteByte(byte(len(uid)))
+	buf.WriteString(uid)
+	return buf.Bytes()
+}
+
+// sampleV2 describes a tree with "a.txt", "dir/" (with a cached mtime) and "dir/b.txt" copied from "a.txt".
+func sampleV2() (docket, data []byte) {
+	var buf bytes.Buffer
+	buf.WriteString("dir/b.txt") // 0
+	buf.WriteString("a.txt")     // 9
+	// dir/b.txt children array at 14
+	buf.Write(v2Node(0, 9, 4, 9, 5, 0, 0, 0, 0, flagWdirTracked, 0, 0, 0))
+	buf.WriteString("a.txt") // 58
+	buf.WriteString("dir")   // 63
+	// root array at 66
+	buf.Write(v2Node(58, 5, 0, 0, 0, 0, 0, 0, 0,
+		flagWdirTracked|flagP1Tracked|flagHasModeAndSize|flagHasMtime|flagModeExecPerm, 42, 1600000000, 123))
+	buf.Write(v2Node(63, 3, 0, 0, 0, 14, 1, 1, 1, flagDirectory|flagHasMtime|flagAllUnknownRecorded, 0, 1600000001, 7))
+	data = buf.Bytes()
+
+	var p1 Node
+	p1[0] = 0xcd
+	docket = v2Docket(p1, 66, 2, 2, 1, uint32(len(data)), "0123456789abcdef")
+	return docket, append(data, "garbage past data size"...)
+}
+
+func TestParseV2(t *testing.T) {
+	docketData, data := sampleV2()
+	docket, err := ParseDocket(docketData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docket.UID != "0123456789abcdef" || docket.DataFilename() != "dirstate.0123456789abcdef" {
+		t.Errorf("UID = %q", docket.UID)
+	}
+
+	ds, err := ParseV2(docket, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Parents[0][0] != 0xcd || ds.Parents[1] != NullNode || ds.Version != 2 {
+		t.Errorf("Parents = %s, %s, Version = %d", ds.Parents[0], ds.Parents[1], ds.Version)
+	}
+
+	want := map[string]Entry{
+		"a.txt":     {Name: "a.txt", State: Normal, Mode: 0100755, Size: 42, Mtime: 1600000000, MtimeNsec: 123},
+		"dir/b.txt": {Name: "dir/b.txt", State: Added, Size: SizeNonNormal, Mtime: MtimeUnset, CopySource: "a.txt"},
+	}
+	if len(ds.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(ds.Entries), len(want))
+	}
+	for _, e := range ds.Entries {
+		if *e != want[e.Name] {
+			t.Errorf("entry %s = %+v, want %+v", e.Name, *e, want[e.Name])
+		}
+	}
+
+	wantDirs := map[string]DirMtime{"dir": {Sec: 1600000001, Nsec: 7, AllUnknownRecorded: true}}
+	if !reflect.DeepEqual(ds.DirMtimes, wantDirs) {
+		t.Errorf("DirMtimes = %+v, want %+v", ds.DirMtimes, wantDirs)
+	}
+}
+
+func TestParseV2Errors(t *testing.T) {
+	docketData, data := sampleV2()
+
+	if _, err := ParseDocket(docketData[:50]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("ParseDocket(short) error = %v, want %v", err, ErrTruncated)
+	}
+	if _, err := ParseDocket(bytes.Repeat([]byte{0}, 200)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ParseDocket(v1 data) error = %v, want %v", err, ErrCorrupt)
+	}
+
+	docket, err := ParseDocket(docketData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseV2(docket, data[:60]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("ParseV2(short data) error = %v, want %v", err, ErrTruncated)
+	}
+
+	docket.rootCount = 1000
+	if _, err := ParseV2(docket, data); !errors.Is(err, ErrTruncated) {
+		t.Errorf("ParseV2(bad root count) error = %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestV2Lossless(t *testing.T) {
+	docketData, data := sampleV2()
+	docket, err := ParseDocket(docketData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := ParseV2(docket, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ds.Lossless() {
+		t.Error("Lossless() = false for data the writer reproduces")
+	}
+
+	// Flags of the a.txt node, the first of the root array.
+	flagsAt := 66 + 30
+	flags := binary.BigEndian.Uint16(data[flagsAt:])
+	for _, extra := range []uint16{flagExpectedStateIsModified, flagMtimeSecondAmbiguous} {
+		lossy := append([]byte(nil), data...)
+		binary.BigEndian.PutUint16(lossy[flagsAt:], flags|extra)
+		ds, err := ParseV2(docket, lossy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds.Lossless() {
+			t.Errorf("Lossless() = true with flag %#x", extra)
+		}
+	}
+}
+
+// TestReadV2SameAsV1 reads testdata/dirstate-v1 and testdata/dirstate-v2,
+// the same working copy in a merge written in both formats, with a file of each state.
+func TestReadV2SameAsV1(t *testing.T) {
+	v1, err := ReadFile(filepath.Join("testdata", "dirstate-v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := ReadFileV2(filepath.Join("testdata", "dirstate-v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v2.Parents != v1.Parents {
+		t.Errorf("Parents = %v, want %v", v2.Parents, v1.Parents)
+	}
+	if len(v1.Entries) != 9 {
+		t.Fatalf("dirstate-v1 has %d entries, want 9", len(v1.Entries))
+	}
+	got, want := sortEntries(v2.Entries), sortEntries(v1.Entries)
+	for i := range want {
+		got[i].v2Flags = 0
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries = %+v\nwant %+v", got, want)
+	}
+
+	wantDirs := map[string]DirMtime{"bin": {Sec: 1600000002, Nsec: 5, AllUnknownRecorded: true}}
+	if !reflect.DeepEqual(v2.DirMtimes, wantDirs) {
+		t.Errorf("DirMtimes = %+v, want %+v", v2.DirMtimes, wantDirs)
+	}
+	if !v2.Lossless() {
+		t.Error("Lossless() = false")
+	}
+}
+
+func sortEntries(entries []*Entry) []Entry {
+	out := make([]Entry, len(entries))
+	for i, e := range entries {
+		out[i] = *e
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func TestWriteV2RoundTrip(t *testing.T) {
+	ds := &DirState{
+		Version: 2,
+		Entries: []*Entry{
+			{Name: "z/y/x.go", State: Normal, Mode: 0100644, Size: 10, Mtime: 1500000000, MtimeNsec: 5},
+			{Name: "a.go", State: Normal, Mode: 0120755, Size: 3, Mtime: MtimeUnset},
+			{Name: "z/new.go", State: Added, Size: SizeNonNormal, Mtime: MtimeUnset, CopySource: "a.go"},
+			{Name: "z/old.go", State: Removed},
+			{Name: "m.go", State: Merged, Size: SizeFromP2, Mtime: MtimeUnset},
+			{Name: "p2.go", State: Normal, Size: SizeFromP2, Mtime: MtimeUnset},
+			{Name: "rm2.go", State: Removed, Size: SizeFromP2},
+		},
+		DirMtimes: map[string]DirMtime{"z/y": {Sec: 1500000001, AllIgnoredRecorded: true}},
+	}
+	ds.Parents[0][5] = 1
+
+	dir, err := ioutil.TempDir("", "dirstate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dirstate")
+
+	if err := WriteFileV2(path, ds); err != nil {
+		t.Fatal(err)
+	}
+	firstData := filepath.Join(dir, "dirstate."+ds.uid)
+
+	got, err := ReadFileV2(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Parents != ds.Parents {
+		t.Errorf("Parents = %v, want %v", got.Parents, ds.Parents)
+	}
+	if !reflect.DeepEqual(sortEntries(got.Entries), sortEntries(ds.Entries)) {
+		t.Errorf("Entries = %+v\nwant %+v", sortEntries(got.Entries), sortEntries(ds.Entries))
+	}
+	if !reflect.DeepEqual(got.DirMtimes, ds.DirMtimes) {
+		t.Errorf("DirMtimes = %+v, want %+v", got.DirMtimes, ds.DirMtimes)
+	}
+
+	// Writing again switches to a new data file and drops the old one.
+	if err := WriteFileV2(path, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(firstData); !os.IsNotExist(err) {
+		t.Errorf("old data file %s still exists", firstData)
+	}
+}
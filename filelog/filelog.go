@@ -0,0 +1,103 @@
+// Package filelog reads file revisions stored in filelogs.
+package filelog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/sashka/hgo/revlog"
+)
+
+// A filelog revision may start with a metadata block used to record copies:
+//
+// 	\1\n<key>: <value>\n...\1\n<file data>
+//
+// File data that itself starts with "\1\n" is always stored with an (empty) metadata block.
+//
+// Source: mercurial/utils/storageutil.py:parsemeta()
+
+var metaMarker = []byte("\x01\n")
+
+// Filelog is the revlog storing the history of a single file.
+type Filelog struct {
+	*revlog.Revlog
+}
+
+// Open opens the filelog stored at indexPath.
+func Open(indexPath string) (*Filelog, error) {
+	rl, err := revlog.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Filelog{rl}, nil
+}
+
+// ParseMeta splits a filelog revision text into its metadata and the file data.
+func ParseMeta(text []byte) (map[string]string, []byte) {
+	if !bytes.HasPrefix(text, metaMarker) {
+		return nil, text
+	}
+	end := bytes.Index(text[2:], metaMarker)
+	if end < 0 {
+		return nil, text
+	}
+
+	meta := make(map[string]string)
+	for _, line := range strings.Split(string(text[2:2+end]), "\n") {
+		if i := strings.Index(line, ": "); i >= 0 {
+			meta[line[:i]] = line[i+2:]
+		}
+	}
+	return meta, text[2+end+2:]
+}
+
+// Read returns the file data of rev without its metadata.
+func (f *Filelog) Read(rev revlog.Rev) ([]byte, error) {
+	text, err := f.Revision(rev)
+	if err != nil {
+		return nil, err
+	}
+	_, data := ParseMeta(text)
+	return data, nil
+}
+
+// Renamed returns the copy source path and file node of rev, if it has one.
+// Like Mercurial, only revisions without a first parent are copies.
+func (f *Filelog) Renamed(rev revlog.Rev) (string, revlog.Node, bool, error) {
+	p1, _, err := f.Parents(rev)
+	if err != nil {
+		return "", revlog.NullNode, false, err
+	}
+	if p1 != revlog.NullRev {
+		return "", revlog.NullNode, false, nil
+	}
+
+	text, err := f.Revision(rev)
+	if err != nil {
+		return "", revlog.NullNode, false, err
+	}
+	meta, _ := ParseMeta(text)
+	source, ok := meta["copy"]
+	if !ok {
+		return "", revlog.NullNode, false, nil
+	}
+
+	var node revlog.Node
+	b, err := hex.DecodeString(meta["copyrev"])
+	if err != nil || len(b) != len(node) {
+		return "", revlog.NullNode, false, fmt.Errorf("%s: invalid copyrev for revision %d", source, rev)
+	}
+	copy(node[:], b)
+	return source, node, true, nil
+}
+
+// Cmp reports whether data differs from the file data of rev.
+func (f *Filelog) Cmp(rev revlog.Rev, data []byte) (bool, error) {
+	stored, err := f.Read(rev)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(stored, data), nil
+}
@@ -0,0 +1,26 @@
+package filelog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMeta(t *testing.T) {
+	tests := []struct {
+		text string
+		meta map[string]string
+		data string
+	}{
+		{text: "plain data\n", data: "plain data\n"},
+		{text: "\x01\ncopy: a.txt\ncopyrev: 0123\n\x01\ncontent", meta: map[string]string{"copy": "a.txt", "copyrev": "0123"}, data: "content"},
+		{text: "\x01\n\x01\n\x01\nstarts with marker", meta: map[string]string{}, data: "\x01\nstarts with marker"},
+		{text: "\x01\nunterminated", data: "\x01\nunterminated"},
+	}
+
+	for _, tt := range tests {
+		meta, data := ParseMeta([]byte(tt.text))
+		if !reflect.DeepEqual(meta, tt.meta) || string(data) != tt.data {
+			t.Errorf("ParseMeta(%q) = (%v, %q), want (%v, %q)", tt.text, meta, data, tt.meta, tt.data)
+		}
+	}
+}
@@ -37,9 +37,21 @@ func Commands() map[string]cli.CommandFactory {
 			return &command.BranchCommand{}, nil
 		},
 
+		"resolve": func() (cli.Command, error) {
+			return &command.ResolveCommand{}, nil
+		},
+
 		"debugdirstate": func() (cli.Command, error) {
 			return &command.DebugDirStateCommand{}, nil
 		},
+
+		"debugignore": func() (cli.Command, error) {
+			return &command.DebugIgnoreCommand{}, nil
+		},
+
+		"debugrequirements": func() (cli.Command, error) {
+			return &command.DebugRequirementsCommand{}, nil
+		},
 	}
 }
 
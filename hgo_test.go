@@ -1,13 +1,111 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sashka/hgo/command"
 )
 
+// prepareLargeRepo creates a working directory with 100 directories of 100 files each.
+// Every other file is tracked in the dirstate with matching stat data.
+// Files are backdated so that their mtimes are not ambiguous and status takes them for clean.
+func prepareLargeRepo(b *testing.B) string {
+	root, err := ioutil.TempDir("", "hgo-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, ".hg"), 0777); err != nil {
+		b.Fatal(err)
+	}
+
+	var ds bytes.Buffer
+	ds.Write(make([]byte, 40))
+	mtime := time.Unix(1500000000, 0)
+	for d := 0; d < 100; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", d))
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < 100; f++ {
+			path := filepath.Join(dir, fmt.Sprintf("file%02d.txt", f))
+			if err := ioutil.WriteFile(path, []byte("content\n"), 0644); err != nil {
+				b.Fatal(err)
+			}
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				b.Fatal(err)
+			}
+			if f%2 == 1 {
+				continue
+			}
+
+			info, err := os.Lstat(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			name := fmt.Sprintf("dir%02d/file%02d.txt", d, f)
+			ds.WriteByte('n')
+			binary.Write(&ds, binary.BigEndian, []int32{0100644, int32(info.Size()), int32(info.ModTime().Unix()), int32(len(name))})
+			ds.WriteString(name)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, ".hg", "dirstate"), ds.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	return root
+}
+
 func BenchmarkStatus100(b *testing.B) {
+	root := prepareLargeRepo(b)
+	defer os.RemoveAll(root)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	stdout := os.Stdout
+	defer func() { os.Stdout = stdout }()
+
+	// The tracked files must be found clean from their stat data alone,
+	// or the benchmark measures content comparisons instead of the walk.
+	out, err := ioutil.TempFile("", "hgo-bench-out")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	os.Stdout = out
 	status := command.StatusCommand{}
+	if code := status.Run([]string{"-m", "-a", "-r", "-d"}); code != 0 {
+		b.Fatalf("status exited with %d", code)
+	}
+	if got, err := ioutil.ReadFile(out.Name()); err != nil {
+		b.Fatal(err)
+	} else if len(got) != 0 {
+		b.Fatalf("status of the tracked files is not clean:\n%s", got)
+	}
+
+	// Status prints every unknown file, keep the benchmark output readable.
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devnull.Close()
+	os.Stdout = devnull
+
+	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		status.Run(make([]string, 0))
 	}
@@ -0,0 +1,72 @@
+// Package manifest parses Mercurial flat manifests.
+package manifest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sashka/hgo/revlog"
+)
+
+// A manifest revision lists every file of a changeset, sorted by path, one per line:
+//
+// 	<path>\0<40-byte hex file node>[flag]\n
+//
+// The optional flag is "x" for executable files, "l" for symlinks and "t" for tree manifest directories.
+//
+// Source: mercurial/manifest.py
+
+// File flags stored in a manifest.
+const (
+	FlagNone    byte = 0
+	FlagExec    byte = 'x'
+	FlagSymlink byte = 'l'
+	FlagTree    byte = 't'
+)
+
+// File is a single file record of a manifest.
+type File struct {
+	Node revlog.Node
+	Flag byte
+}
+
+// Manifest maps file paths to their file revisions.
+type Manifest map[string]File
+
+// Parse decodes the text of a manifest revision.
+func Parse(text []byte) (Manifest, error) {
+	m := make(Manifest)
+	lineno := 0
+	for len(text) > 0 {
+		lineno++
+		nl := bytes.IndexByte(text, '\n')
+		if nl < 0 {
+			return nil, fmt.Errorf("manifest line %d: missing newline", lineno)
+		}
+		line := text[:nl]
+		text = text[nl+1:]
+
+		sep := bytes.IndexByte(line, 0)
+		if sep <= 0 {
+			return nil, fmt.Errorf("manifest line %d: missing file name", lineno)
+		}
+		path, rest := string(line[:sep]), line[sep+1:]
+
+		var f File
+		switch len(rest) {
+		case 2 * len(f.Node):
+		case 2*len(f.Node) + 1:
+			f.Flag = rest[len(rest)-1]
+			rest = rest[:len(rest)-1]
+		default:
+			return nil, fmt.Errorf("manifest line %d: invalid node for %s", lineno, path)
+		}
+		if _, err := hex.Decode(f.Node[:], rest); err != nil {
+			return nil, fmt.Errorf("manifest line %d: invalid node for %s", lineno, path)
+		}
+
+		m[path] = f
+	}
+	return m, nil
+}
@@ -0,0 +1,43 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	node := strings.Repeat("ab", 20)
+	text := "a.txt\x00" + node + "\n" + "bin/run\x00" + node + "x\n" + "link\x00" + node + "l\n"
+
+	m, err := Parse([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]byte{"a.txt": FlagNone, "bin/run": FlagExec, "link": FlagSymlink}
+	if len(m) != len(want) {
+		t.Fatalf("got %d files, want %d", len(m), len(want))
+	}
+	for path, flag := range want {
+		f, ok := m[path]
+		if !ok || f.Flag != flag || f.Node.String() != node {
+			t.Errorf("m[%s] = %v, %v, want flag %q and node %s", path, f, ok, flag, node)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	node := strings.Repeat("ab", 20)
+	tests := []string{
+		"a.txt\x00" + node,
+		"\x00" + node + "\n",
+		"a.txt " + node + "\n",
+		"a.txt\x00" + node[:39] + "\n",
+		"a.txt\x00" + strings.Repeat("zz", 20) + "\n",
+	}
+	for _, text := range tests {
+		if _, err := Parse([]byte(text)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", text)
+		}
+	}
+}
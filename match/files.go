@@ -0,0 +1,216 @@
+package match
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// argPrefixes are the "kind:" prefixes accepted on the command line.
+var argPrefixes = []struct {
+	prefix string
+	syntax Syntax
+}{
+	{"path:", Path},
+	{"relpath:", RelPath},
+	{"glob:", Glob},
+	{"relglob:", RelGlob},
+	{"rootglob:", RootGlob},
+	{"re:", Regexp},
+	{"relre:", RelRegexp},
+	{"rootfilesin:", RootFilesIn},
+	{"set:", Set},
+}
+
+// FileMatcher selects files by command-line patterns, as in "hgo status src/foo".
+type FileMatcher struct {
+	always  bool
+	matcher *Matcher
+	sets    []*Fileset
+	roots   []string
+}
+
+// NewFileMatcher compiles command-line arguments for the repository at root.
+//
+// Arguments without a "kind:" prefix are paths relative to cwd, matching files
+// and everything below directories. glob: patterns are relative to cwd too,
+// listfile: and listfile0: read more arguments from a file, one per line or
+// separated by NUL bytes, and set: are fileset expressions.
+// With no arguments, every file matches. Arguments that expand to no patterns,
+// such as an empty listfile:, match no file.
+func NewFileMatcher(root, cwd string, args []string) (*FileMatcher, error) {
+	var pats []Pattern
+	fm := &FileMatcher{always: len(args) == 0}
+	for _, arg := range args {
+		argPats, err := parseArg(root, cwd, arg, RelPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range argPats {
+			if p.Syntax != Set {
+				pats = append(pats, p)
+				continue
+			}
+			fs, err := ParseFileset(root, cwd, p.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			fm.sets = append(fm.sets, fs)
+		}
+	}
+
+	m, err := compile(pats, fileGlobSuffix)
+	if err != nil {
+		return nil, err
+	}
+	fm.matcher = m
+	fm.roots = patternRoots(pats)
+	if fm.roots == nil && len(pats) == 0 && len(fm.sets) == 0 && !fm.always {
+		fm.roots = []string{}
+	}
+	return fm, nil
+}
+
+// parseArg splits a command-line argument into its kind and pattern,
+// making cwd-relative patterns relative to root.
+func parseArg(root, cwd, arg string, defaultSyntax Syntax) ([]Pattern, error) {
+	if strings.HasPrefix(arg, "listfile:") || strings.HasPrefix(arg, "listfile0:") {
+		return readListFile(root, cwd, arg)
+	}
+
+	p := Pattern{Syntax: defaultSyntax, Pattern: arg}
+	for _, ap := range argPrefixes {
+		if strings.HasPrefix(arg, ap.prefix) {
+			p.Syntax = ap.syntax
+			p.Pattern = arg[len(ap.prefix):]
+			break
+		}
+	}
+
+	switch p.Syntax {
+	case RelPath, Glob:
+		canon, err := CanonPath(root, cwd, filepath.FromSlash(p.Pattern))
+		if err != nil {
+			return nil, err
+		}
+		p.Pattern = canon
+		if p.Syntax == RelPath && p.Pattern == "" {
+			p.Pattern = "."
+		}
+	default:
+		p.normalize()
+	}
+
+	return []Pattern{p}, nil
+}
+
+// readListFile reads the arguments listed in the file named by a listfile: or listfile0: argument.
+func readListFile(root, cwd, arg string) ([]Pattern, error) {
+	sep := []byte("\n")
+	name := strings.TrimPrefix(arg, "listfile:")
+	if strings.HasPrefix(arg, "listfile0:") {
+		sep = []byte{0}
+		name = strings.TrimPrefix(arg, "listfile0:")
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(cwd, name)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file list (%s)", name)
+	}
+
+	var pats []Pattern
+	for _, line := range bytes.Split(data, sep) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+		linePats, err := parseArg(root, cwd, string(line), RelPath)
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, linePats...)
+	}
+	return pats, nil
+}
+
+// patternRoots returns the directories and files below which pats can match,
+// or nil when they can match anywhere. Mechanical translation of match._roots.
+func patternRoots(pats []Pattern) []string {
+	if len(pats) == 0 {
+		return nil
+	}
+
+	var roots []string
+	for _, p := range pats {
+		root := ""
+		switch p.Syntax {
+		case Glob, RootGlob:
+			var parts []string
+			for _, part := range strings.Split(p.Pattern, "/") {
+				if strings.ContainsAny(part, "[{*?\\") {
+					break
+				}
+				parts = append(parts, part)
+			}
+			root = strings.Join(parts, "/")
+		case Path, RelPath, RootFilesIn:
+			if p.Pattern != "." {
+				root = p.Pattern
+			}
+		}
+		if root == "" {
+			return nil
+		}
+		roots = append(roots, root)
+	}
+
+	// Drop roots below other roots.
+	sort.Strings(roots)
+	var res []string
+	for _, r := range roots {
+		if n := len(res); n > 0 && (r == res[n-1] || strings.HasPrefix(r, res[n-1]+"/")) {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// Always reports whether every file matches.
+func (fm *FileMatcher) Always() bool {
+	return fm.always
+}
+
+// Roots returns the directories and files the matching files are in or below,
+// or nil when they may be anywhere.
+func (fm *FileMatcher) Roots() []string {
+	if len(fm.sets) > 0 {
+		return nil
+	}
+	return fm.roots
+}
+
+// MayMatch reports whether path may match: it matches a pattern,
+// or it depends on filesets to tell.
+func (fm *FileMatcher) MayMatch(path string) bool {
+	return fm.Always() || len(fm.sets) > 0 || fm.matcher.Match(path)
+}
+
+// MatchFile reports whether the file at path matches a pattern or is in a fileset.
+func (fm *FileMatcher) MatchFile(path string, fi FileInfo) bool {
+	if fm.Always() || fm.matcher.Match(path) {
+		return true
+	}
+	for _, fs := range fm.sets {
+		if fs.Match(path, fi) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package match
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileMatcher(t *testing.T) {
+	tests := []struct {
+		cwd   string
+		args  []string
+		roots []string
+		match []string
+		skip  []string
+	}{
+		{
+			cwd:   "/repo",
+			args:  nil,
+			match: []string{"a", "src/a.go"},
+		},
+		{
+			cwd:   "/repo/src",
+			args:  []string{"foo", "../docs/a.txt"},
+			roots: []string{"docs/a.txt", "src/foo"},
+			match: []string{"src/foo", "src/foo/bar.go", "docs/a.txt"},
+			skip:  []string{"src/foobar", "foo", "docs/b.txt"},
+		},
+		{
+			cwd:   "/repo/src",
+			args:  []string{"glob:*.go", "path:lib"},
+			roots: []string{"lib", "src"},
+			match: []string{"src/a.go", "lib", "lib/x/y.c"},
+			skip:  []string{"src/sub/a.go", "a.go", "library/a.c"},
+		},
+		{
+			cwd:   "/repo",
+			args:  []string{"glob:src/**/*.go", "src/x"},
+			roots: []string{"src"},
+			match: []string{"src/a.go", "src/sub/b.go", "src/x/y"},
+			skip:  []string{"src/a.c"},
+		},
+		{
+			cwd:   "/repo/src",
+			args:  []string{"re:.*\\.c$"},
+			roots: nil,
+			match: []string{"a.c", "src/sub/b.c"},
+			skip:  []string{"a.go"},
+		},
+		{
+			cwd:   "/repo",
+			args:  []string{"rootfilesin:src"},
+			roots: []string{"src"},
+			match: []string{"src/a.go"},
+			skip:  []string{"src/sub/b.go", "a.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		fm, err := NewFileMatcher("/repo", tt.cwd, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if roots := fm.Roots(); !reflect.DeepEqual(roots, tt.roots) {
+			t.Errorf("%v: Roots() = %q, want %q", tt.args, roots, tt.roots)
+		}
+		for _, path := range tt.match {
+			if !fm.MatchFile(path, FileInfo{}) {
+				t.Errorf("%v: MatchFile(%q) = false, want true", tt.args, path)
+			}
+		}
+		for _, path := range tt.skip {
+			if fm.MatchFile(path, FileInfo{}) {
+				t.Errorf("%v: MatchFile(%q) = true, want false", tt.args, path)
+			}
+		}
+	}
+}
+
+func TestFileMatcherListFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "match-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "list"), []byte("a.txt\nglob:*.go\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "list0"), []byte("b.txt\x00c.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fm, err := NewFileMatcher("/repo", "/repo/src", []string{"listfile:" + filepath.Join(dir, "list"), "listfile0:" + filepath.Join(dir, "list0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"src/a.txt", "src/main.go", "src/b.txt", "src/c.txt"} {
+		if !fm.MatchFile(path, FileInfo{}) {
+			t.Errorf("MatchFile(%q) = false, want true", path)
+		}
+	}
+	if fm.MatchFile("a.txt", FileInfo{}) {
+		t.Error("MatchFile(a.txt) = true, want false")
+	}
+
+	if _, err := NewFileMatcher("/repo", "/repo", []string{"listfile:" + filepath.Join(dir, "missing")}); err == nil {
+		t.Error("NewFileMatcher() succeeded with a missing list file")
+	}
+
+	// Like Mercurial, a list without any file matches nothing.
+	if err := ioutil.WriteFile(filepath.Join(dir, "blank"), []byte("\n\r\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fm, err = NewFileMatcher("/repo", "/repo", []string{"listfile:" + filepath.Join(dir, "blank")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fm.Always() || fm.MatchFile("a.txt", FileInfo{}) || fm.MayMatch("a.txt") {
+		t.Error("an empty listfile matches a.txt")
+	}
+	if roots := fm.Roots(); roots == nil || len(roots) != 0 {
+		t.Errorf("Roots() = %q, want no roots", roots)
+	}
+}
+
+func TestFileMatcherSet(t *testing.T) {
+	fm, err := NewFileMatcher("/repo", "/repo", []string{"set:modified() and *.go", "README"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fm.Roots() != nil {
+		t.Errorf("Roots() = %q, want the whole tree", fm.Roots())
+	}
+	if !fm.MayMatch("anything") {
+		t.Error("MayMatch() = false with a fileset")
+	}
+
+	tests := []struct {
+		path   string
+		status byte
+		match  bool
+	}{
+		{"a.go", 'M', true},
+		{"a.go", 'C', false},
+		{"src/a.go", 'M', false},
+		{"README", 'C', true},
+	}
+	for _, tt := range tests {
+		if got := fm.MatchFile(tt.path, FileInfo{Status: tt.status}); got != tt.match {
+			t.Errorf("MatchFile(%q, %c) = %v, want %v", tt.path, tt.status, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package match
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// FileInfo is what fileset predicates know about a file.
+type FileInfo struct {
+	// Status is the code hgo status prints for the file: 'M', 'A', 'R', '!', '?', 'I' or 'C'.
+	Status byte
+
+	// Stat is the stat data of the file, when already known.
+	Stat os.FileInfo
+}
+
+// Fileset is a compiled fileset expression, see "hg help filesets".
+//
+// Supported are patterns (globs relative to the current directory by default),
+// the operators "not", "and", "or" and "-" (also "!", "&" and "|"), parentheses,
+// and the predicates modified(), added(), removed(), deleted(), missing(),
+// unknown(), ignored(), clean(), exec() and symlink().
+type Fileset struct {
+	root string
+	eval filesetFunc
+}
+
+type filesetFunc func(fs *Fileset, path string, fi *FileInfo) bool
+
+// ParseFileset compiles a fileset expression for the repository at root.
+func ParseFileset(root, cwd, expr string) (*Fileset, error) {
+	tokens, err := tokenizeFileset(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filesetParser{root: root, cwd: cwd, tokens: tokens}
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEnd {
+		return nil, fmt.Errorf("fileset parse error at %d: invalid token", t.pos)
+	}
+
+	return &Fileset{root: root, eval: eval}, nil
+}
+
+// Match reports whether the file at path is in the set.
+func (fs *Fileset) Match(path string, fi FileInfo) bool {
+	return fs.eval(fs, path, &fi)
+}
+
+// lstat returns the stat data of path, nil if the file is missing.
+func (fs *Fileset) lstat(path string, fi *FileInfo) os.FileInfo {
+	if fi.Stat == nil && fi.Status != 'R' && fi.Status != '!' {
+		fi.Stat, _ = os.Lstat(filepath.Join(fs.root, filepath.FromSlash(path)))
+	}
+	return fi.Stat
+}
+
+func statusPredicate(code byte) filesetFunc {
+	return func(fs *Fileset, path string, fi *FileInfo) bool {
+		return fi.Status == code
+	}
+}
+
+var filesetPredicates = map[string]filesetFunc{
+	"modified": statusPredicate('M'),
+	"added":    statusPredicate('A'),
+	"removed":  statusPredicate('R'),
+	"deleted":  statusPredicate('!'),
+	"missing":  statusPredicate('!'),
+	"unknown":  statusPredicate('?'),
+	"ignored":  statusPredicate('I'),
+	"clean":    statusPredicate('C'),
+	"exec": func(fs *Fileset, path string, fi *FileInfo) bool {
+		info := fs.lstat(path, fi)
+		return info != nil && info.Mode().IsRegular() && info.Mode()&0100 != 0
+	},
+	"symlink": func(fs *Fileset, path string, fi *FileInfo) bool {
+		info := fs.lstat(path, fi)
+		return info != nil && info.Mode()&os.ModeSymlink != 0
+	},
+}
+
+type tokenKind int
+
+const (
+	tokEnd tokenKind = iota
+	tokSymbol
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// isSymbolChar reports whether c may appear in a bare word: names and unquoted globs.
+func isSymbolChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c > unicode.MaxASCII || strings.ContainsRune(".*{}[]?/\\_", c)
+}
+
+func tokenizeFileset(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for pos := 0; pos < len(runes); {
+		c := runes[pos]
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case strings.ContainsRune("()|&!-,:", c):
+			tokens = append(tokens, token{kind: tokOp, value: string(c), pos: pos})
+			pos++
+		case c == '\'' || c == '"':
+			start := pos
+			var b strings.Builder
+			pos++
+			for ; pos < len(runes) && runes[pos] != c; pos++ {
+				if runes[pos] == '\\' && pos+1 < len(runes) {
+					pos++
+				}
+				b.WriteRune(runes[pos])
+			}
+			if pos >= len(runes) {
+				return nil, fmt.Errorf("fileset parse error at %d: unterminated string", start)
+			}
+			pos++
+			tokens = append(tokens, token{kind: tokString, value: b.String(), pos: start})
+		case isSymbolChar(c):
+			start := pos
+			for pos < len(runes) && isSymbolChar(runes[pos]) {
+				pos++
+			}
+			sym := string(runes[start:pos])
+			kind := tokSymbol
+			if sym == "and" || sym == "or" || sym == "not" {
+				kind = tokOp
+			}
+			tokens = append(tokens, token{kind: kind, value: sym, pos: start})
+		default:
+			return nil, fmt.Errorf("fileset parse error at %d: syntax error", pos)
+		}
+	}
+	return append(tokens, token{kind: tokEnd, pos: len(runes)}), nil
+}
+
+type filesetParser struct {
+	root, cwd string
+	tokens    []token
+}
+
+func (p *filesetParser) peek() token {
+	return p.tokens[0]
+}
+
+func (p *filesetParser) next() token {
+	t := p.tokens[0]
+	if t.kind != tokEnd {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+func (p *filesetParser) isOp(values ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp {
+		return false
+	}
+	for _, v := range values {
+		if t.value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filesetParser) parseOr() (filesetFunc, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("or", "|") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orFunc(l, r)
+	}
+	return l, nil
+}
+
+func (p *filesetParser) parseAnd() (filesetFunc, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("and", "&", "-") {
+		minus := p.next().value == "-"
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if minus {
+			r = notFunc(r)
+		}
+		l = andFunc(l, r)
+	}
+	return l, nil
+}
+
+func (p *filesetParser) parseNot() (filesetFunc, error) {
+	if p.isOp("not", "!") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notFunc(x), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filesetParser) parsePrimary() (filesetFunc, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokOp && t.value == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, fmt.Errorf("fileset parse error at %d: missing )", p.peek().pos)
+		}
+		p.next()
+		return x, nil
+
+	case t.kind == tokSymbol && p.isOp("("):
+		p.next()
+		if p.peek().kind == tokEnd {
+			return nil, fmt.Errorf("fileset parse error at %d: missing )", p.peek().pos)
+		}
+		if !p.isOp(")") {
+			return nil, fmt.Errorf("%s takes no arguments", t.value)
+		}
+		p.next()
+		pred, ok := filesetPredicates[t.value]
+		if !ok {
+			return nil, fmt.Errorf("unknown identifier: %s", t.value)
+		}
+		return pred, nil
+
+	case t.kind == tokSymbol && p.isOp(":"):
+		p.next()
+		pat := p.next()
+		if pat.kind != tokSymbol && pat.kind != tokString {
+			return nil, fmt.Errorf("fileset parse error at %d: pattern must be a string", pat.pos)
+		}
+		return p.pattern(t.value + ":" + pat.value)
+
+	case t.kind == tokSymbol || t.kind == tokString:
+		return p.pattern(t.value)
+	}
+
+	return nil, fmt.Errorf("fileset parse error at %d: not a prefix: %s", t.pos, t.value)
+}
+
+// pattern compiles a file pattern, a glob by default.
+func (p *filesetParser) pattern(arg string) (filesetFunc, error) {
+	pats, err := parseArg(p.root, p.cwd, arg, Glob)
+	if err != nil {
+		return nil, err
+	}
+	for _, pat := range pats {
+		if pat.Syntax == Set {
+			return nil, fmt.Errorf("set: patterns are not allowed in filesets")
+		}
+	}
+	m, err := compile(pats, fileGlobSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return func(fs *Fileset, path string, fi *FileInfo) bool {
+		return m.Match(path)
+	}, nil
+}
+
+func orFunc(l, r filesetFunc) filesetFunc {
+	return func(fs *Fileset, path string, fi *FileInfo) bool {
+		return l(fs, path, fi) || r(fs, path, fi)
+	}
+}
+
+func andFunc(l, r filesetFunc) filesetFunc {
+	return func(fs *Fileset, path string, fi *FileInfo) bool {
+		return l(fs, path, fi) && r(fs, path, fi)
+	}
+}
+
+func notFunc(x filesetFunc) filesetFunc {
+	return func(fs *Fileset, path string, fi *FileInfo) bool {
+		return !x(fs, path, fi)
+	}
+}
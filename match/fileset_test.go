@@ -0,0 +1,46 @@
+package match
+
+import (
+	"testing"
+)
+
+func TestFileset(t *testing.T) {
+	tests := []struct {
+		expr   string
+		path   string
+		status byte
+		match  bool
+	}{
+		{"modified()", "a", 'M', true},
+		{"modified()", "a", 'A', false},
+		{"added() or removed()", "a", 'R', true},
+		{"not clean()", "a", 'C', false},
+		{"!clean()", "a", '?', true},
+		{"*.c", "a.c", 'C', true},
+		{"*.c", "src/a.c", 'C', false},
+		{"**.c", "src/a.c", 'C', true},
+		{"glob:src/*.c & modified()", "src/a.c", 'M', true},
+		{"'re:\\.h$' - unknown()", "src/a.h", '?', false},
+		{"(modified() | added()) and path:src", "src/x/a.c", 'A', true},
+		{"missing() and deleted()", "a", '!', true},
+	}
+
+	for _, tt := range tests {
+		fs, err := ParseFileset("/repo", "/repo", tt.expr)
+		if err != nil {
+			t.Errorf("ParseFileset(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := fs.Match(tt.path, FileInfo{Status: tt.status}); got != tt.match {
+			t.Errorf("%q: Match(%q, %c) = %v, want %v", tt.expr, tt.path, tt.status, got, tt.match)
+		}
+	}
+}
+
+func TestFilesetErrors(t *testing.T) {
+	for _, expr := range []string{"", "modified(", "nosuch()", "modified() and", "'unterminated", "a ) b", "added(x)"} {
+		if _, err := ParseFileset("/repo", "/repo", expr); err == nil {
+			t.Errorf("ParseFileset(%q) succeeded", expr)
+		}
+	}
+}
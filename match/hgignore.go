@@ -0,0 +1,150 @@
+package match
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// fileSyntaxes maps the names accepted by "syntax:" lines to pattern kinds.
+var fileSyntaxes = map[string]Syntax{
+	"re":       RelRegexp,
+	"regexp":   RelRegexp,
+	"glob":     RelGlob,
+	"rootglob": RootGlob,
+}
+
+// linePrefixes are the "kind:" prefixes a single line may use.
+var linePrefixes = []struct {
+	prefix string
+	syntax Syntax
+}{
+	{"re:", RelRegexp},
+	{"regexp:", RelRegexp},
+	{"glob:", RelGlob},
+	{"relglob:", RelGlob},
+	{"relre:", RelRegexp},
+	{"rootglob:", RootGlob},
+	{"path:", Path},
+	{"relpath:", RelPath},
+	{"rootfilesin:", RootFilesIn},
+	{"include:", Include},
+	{"subinclude:", SubInclude},
+}
+
+// ReadPatternFile parses a pattern file, returning a list of
+// patterns. These patterns should be given to Compile()
+// to be validated and converted into a match function.
+//
+// trailing white space is dropped.
+// the escape character is backslash.
+// comments start with #.
+// empty lines are skipped.
+//
+// lines can be of the following formats:
+//
+// syntax: regexp      # defaults following lines to non-rooted regexps
+// syntax: glob        # defaults following lines to non-rooted globs
+// syntax: rootglob    # defaults following lines to rooted globs
+// re:pattern          # non-rooted regular expression
+// glob:pattern        # non-rooted glob
+// rootglob:pattern    # rooted glob
+// path:path           # file or directory relative to the root
+// rootfilesin:path    # files of a directory, not its subdirectories
+// include:path        # patterns of another file, relative to this one
+// subinclude:path     # same, applied below the directory of that file only
+// pattern             # pattern of the current default type
+//
+// relglob:, relre: and relpath: are accepted as well.
+// The default type is regexp.
+//
+// Every pattern keeps its line number and original line.
+// This is useful to debug ignore patterns.
+func ReadPatternFile(filepath string) ([]Pattern, error) {
+	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	currentSyntax := RelRegexp
+	commentre := regexp.MustCompile("((?:^|[^\\\\])(?:\\\\\\\\)*)#.*")
+
+	// read line by line
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanLines)
+
+	lineno := 0
+	pats := make([]Pattern, 0)
+
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		lineno++
+
+		if s == "" {
+			continue
+		}
+
+		original := s
+
+		// Strip comment
+		if strings.Contains(s, "#") {
+			loc := commentre.FindStringSubmatchIndex(s)
+			if len(loc) > 0 {
+				// The first group keeps the character and backslashes before the #.
+				s = strings.TrimSpace(s[:loc[3]])
+			}
+			s = strings.Replace(s, "\\#", "#", -1)
+		}
+
+		if s == "" {
+			continue
+		}
+
+		if strings.HasPrefix(s, "syntax:") {
+			s := strings.TrimSpace(s[7:])
+			if s == "" {
+				continue
+			}
+
+			syntax, ok := fileSyntaxes[s]
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid syntax '%s'", filepath, s)
+			}
+			currentSyntax = syntax
+			continue
+		}
+
+		pat := Pattern{
+			Syntax:   currentSyntax,
+			Pattern:  s,
+			Source:   filepath,
+			Lineno:   lineno,
+			Original: original,
+		}
+		for _, lp := range linePrefixes {
+			if strings.HasPrefix(s, lp.prefix) {
+				pat.Syntax = lp.syntax
+				pat.Pattern = s[len(lp.prefix):]
+				break
+			}
+		}
+		pat.normalize()
+
+		pats = append(pats, pat)
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Handle the error
+		return nil, err
+	}
+
+	return pats, nil
+}
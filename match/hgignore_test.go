@@ -0,0 +1,158 @@
+package match
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writePatternFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "match-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".hgignore")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadPatternFile(t *testing.T) {
+	path := writePatternFile(t, `# comment
+\.pyc$
+syntax: glob
+*.o  # object files
+re:^build/
+relre:tmp
+rootglob:vendor/
+path:third_party/lib
+relpath:docs
+rootfilesin:logs
+glob:a\#b
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	pats, err := ReadPatternFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type kp struct {
+		Syntax  Syntax
+		Pattern string
+		Lineno  int
+	}
+	want := []kp{
+		{RelRegexp, `\.pyc$`, 2},
+		{RelGlob, "*.o", 4},
+		{RelRegexp, "^build/", 5},
+		{RelRegexp, "tmp", 6},
+		{RootGlob, "vendor", 7},
+		{Path, "third_party/lib", 8},
+		{RelPath, "docs", 9},
+		{RootFilesIn, "logs", 10},
+		{RelGlob, "a#b", 11},
+	}
+	var got []kp
+	for _, p := range pats {
+		got = append(got, kp{p.Syntax, p.Pattern, p.Lineno})
+		if p.Source != path {
+			t.Errorf("Source = %q, want %q", p.Source, path)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadPatternFile() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestReadPatternFileInvalidSyntax(t *testing.T) {
+	path := writePatternFile(t, "syntax: fancy\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := ReadPatternFile(path); err == nil {
+		t.Error("ReadPatternFile() succeeded on an invalid syntax line")
+	}
+}
+
+func TestReadPatternFileSyntax(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		syntax  Syntax
+		match   []string
+		nomatch []string
+	}{
+		{
+			// Like Mercurial, patterns are regular expressions matching anywhere
+			// in the path until a syntax line says otherwise.
+			name:    "default",
+			content: "a.c\n^build\n",
+			syntax:  RelRegexp,
+			match:   []string{"a.c", "src/abc", "xa.cx", "build/out.o"},
+			nomatch: []string{"a.h", "src/build/out.o"},
+		},
+		{
+			name:    "glob",
+			content: "syntax: glob\na.c\nbuild\n",
+			syntax:  RelGlob,
+			match:   []string{"a.c", "src/a.c", "build/out.o", "src/build/out.o"},
+			nomatch: []string{"abc", "xa.cx", "builder"},
+		},
+	}
+
+	for _, tt := range tests {
+		path := writePatternFile(t, tt.content)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		pats, err := ReadPatternFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pats {
+			if p.Syntax != tt.syntax {
+				t.Errorf("%s: %s has syntax %s, want %s", tt.name, p.Pattern, p.Syntax, tt.syntax)
+			}
+		}
+
+		m, err := Compile(pats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range tt.match {
+			if !m.Match(f) {
+				t.Errorf("%s: %s not matched", tt.name, f)
+			}
+		}
+		for _, f := range tt.nomatch {
+			if m.Match(f) {
+				t.Errorf("%s: %s matched", tt.name, f)
+			}
+		}
+	}
+}
+
+func TestReadPatternFileBareGlob(t *testing.T) {
+	// Without a syntax line "*.o" is a regexp, not a glob, and an invalid one:
+	// Mercurial aborts with "invalid pattern (relre): *.o".
+	path := writePatternFile(t, "*.o\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	pats, err := ReadPatternFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pats) != 1 || pats[0].Syntax != RelRegexp {
+		t.Fatalf("ReadPatternFile() = %+v, want one relre pattern", pats)
+	}
+	_, err = Compile(pats)
+	if err == nil {
+		t.Fatal("Compile() accepted *.o as a regexp")
+	}
+	if want := "invalid pattern (relre): *.o"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Compile() error = %q, want it to contain %q", err, want)
+	}
+}
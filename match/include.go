@@ -0,0 +1,115 @@
+package match
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// subMatcher applies the patterns of a subincluded file to the paths below its directory.
+type subMatcher struct {
+	// prefix is the slash-separated directory of the file with a trailing slash,
+	// or "" for a file at the root.
+	prefix  string
+	matcher *Matcher
+}
+
+// Load reads the pattern files at paths, following include: and subinclude:
+// directives, and compiles all their patterns into a single matcher.
+//
+// Included files are found relative to the including file.
+// Patterns of a subincluded file only apply below its directory, to paths
+// relative to it. root is the repository root the matcher is used for.
+// Missing files are skipped, include cycles are errors.
+func Load(root string, paths []string) (*Matcher, error) {
+	l := &loader{root: root}
+	return l.load(paths)
+}
+
+type loader struct {
+	root string
+
+	// stack holds the files being read, to detect include cycles.
+	stack []string
+}
+
+func (l *loader) load(paths []string) (*Matcher, error) {
+	var pats []Pattern
+	var subs []subMatcher
+	for _, path := range paths {
+		var err error
+		pats, subs, err = l.expand(filepath.Clean(path), pats, subs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := Compile(pats)
+	if err != nil {
+		return nil, err
+	}
+	m.subs = subs
+	return m, nil
+}
+
+// expand appends the patterns of the file at path to pats,
+// replacing include: by the included patterns and subinclude: by matchers in subs.
+func (l *loader) expand(path string, pats []Pattern, subs []subMatcher) ([]Pattern, []subMatcher, error) {
+	l.stack = append(l.stack, path)
+	defer func() {
+		l.stack = l.stack[:len(l.stack)-1]
+	}()
+
+	filePats, err := ReadPatternFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, p := range filePats {
+		if p.Syntax != Include && p.Syntax != SubInclude {
+			pats = append(pats, p)
+			continue
+		}
+
+		included := filepath.Join(filepath.Dir(path), filepath.FromSlash(p.Pattern))
+		if err := l.checkCycle(&p, included); err != nil {
+			return nil, nil, err
+		}
+
+		if p.Syntax == Include {
+			pats, subs, err = l.expand(included, pats, subs)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		dir, err := filepath.Rel(l.root, filepath.Dir(included))
+		if err != nil || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return nil, nil, fmt.Errorf("%s%s is not in the repository", p.location(), p.Pattern)
+		}
+		prefix := ""
+		if dir != "." {
+			prefix = filepath.ToSlash(dir) + "/"
+		}
+
+		m, err := l.load([]string{included})
+		if err != nil {
+			return nil, nil, err
+		}
+		subs = append(subs, subMatcher{prefix: prefix, matcher: m})
+	}
+
+	return pats, subs, nil
+}
+
+// checkCycle fails when the file p includes is already being read.
+func (l *loader) checkCycle(p *Pattern, included string) error {
+	for i, path := range l.stack {
+		if path == included {
+			chain := append(append([]string(nil), l.stack[i:]...), included)
+			return fmt.Errorf("%sinclude cycle: %s", p.location(), strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package match
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "match-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestLoad(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		".hgignore":           "syntax: glob\n*.o\ninclude:ignore/common\nsubinclude:web/.hgignore\n",
+		"ignore/common":       "syntax: glob\n*.tmp\n",
+		"web/.hgignore":       "syntax: rootglob\nnode_modules\ninclude:.hgignore-extra\n",
+		"web/.hgignore-extra": "re:^dist/\n",
+		"global":              "glob:*.swp\n",
+	})
+	defer os.RemoveAll(root)
+
+	m, err := Load(root, []string{filepath.Join(root, ".hgignore"), filepath.Join(root, "global")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		match bool
+	}{
+		{"a.o", true},
+		{"src/a.tmp", true},
+		{"a.swp", true},
+		{"web/node_modules/x.js", true},
+		{"node_modules/x.js", false},
+		{"web/sub/node_modules/x.js", false},
+		{"web/dist/app.js", true},
+		{"dist/app.js", false},
+		{"web/src/app.js", false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path); got != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestLoadMissingInclude(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		".hgignore": "include:missing\nglob:*.o\n",
+	})
+	defer os.RemoveAll(root)
+
+	m, err := Load(root, []string{filepath.Join(root, ".hgignore"), filepath.Join(root, "missing-too")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match("a.o") {
+		t.Error("Match(a.o) = false, want true")
+	}
+}
+
+func TestLoadCycle(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		".hgignore": "include:a\n",
+		"a":         "glob:*.o\nsubinclude:sub/b\n",
+		"sub/b":     "include:../a\n",
+	})
+	defer os.RemoveAll(root)
+
+	_, err := Load(root, []string{filepath.Join(root, ".hgignore")})
+	if err == nil {
+		t.Fatal("Load() succeeded on an include cycle")
+	}
+	if !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Load() error = %q, want an include cycle", err)
+	}
+}
+
+func TestLoadOutsideRoot(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"repo/.hgignore":  "subinclude:../other/.hgignore\n",
+		"other/.hgignore": "glob:*.o\n",
+	})
+	defer os.RemoveAll(root)
+
+	if _, err := Load(filepath.Join(root, "repo"), []string{filepath.Join(root, "repo", ".hgignore")}); err == nil {
+		t.Error("Load() succeeded on a subinclude outside the repository")
+	}
+}
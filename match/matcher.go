@@ -0,0 +1,259 @@
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	radix "github.com/armon/go-radix"
+)
+
+// Glob suffixes: globs of pattern files match directories and everything below them,
+// globs given on the command line match files only.
+const (
+	ignoreGlobSuffix = "(?:/|$)"
+	fileGlobSuffix   = "$"
+)
+
+// How a literal stored in the radix trees matches a path it is a prefix of.
+const (
+	// matchPrefix matches any path starting with the literal.
+	matchPrefix = 1 << iota
+	// matchExact matches the literal itself.
+	matchExact
+	// matchPath matches the literal itself and everything below it.
+	matchPath
+)
+
+// Matcher is a set of patterns compiled into a single matcher.
+//
+// Patterns that are plain literals are looked up in radix trees,
+// all the others are joined into one regexp.
+type Matcher struct {
+	// rooted holds literals matched at the start of the path,
+	// unrooted holds literals matched at the start of any path component.
+	rooted   *radix.Tree
+	unrooted *radix.Tree
+	re       *regexp.Regexp
+
+	// subs hold the patterns of subincluded files, see Load.
+	subs []subMatcher
+
+	// pats are the patterns the matcher was compiled from.
+	pats       []Pattern
+	globSuffix string
+}
+
+// Compile builds a matcher for pats, read from pattern files such as .hgignore.
+// A path matches if any of the patterns matches.
+func Compile(pats []Pattern) (*Matcher, error) {
+	return compile(pats, ignoreGlobSuffix)
+}
+
+func compile(pats []Pattern, globSuffix string) (*Matcher, error) {
+	m := &Matcher{rooted: radix.New(), unrooted: radix.New(), pats: pats, globSuffix: globSuffix}
+
+	var res []string
+	for i := range pats {
+		p := &pats[i]
+		switch p.Syntax {
+		case Include, SubInclude:
+			return nil, fmt.Errorf("%s%s patterns need Load", p.location(), p.Syntax)
+		case Set:
+			return nil, fmt.Errorf("%s%s patterns need ParseFileset", p.location(), p.Syntax)
+		}
+
+		source := p.regexp(globSuffix)
+		re, err := regexp.Compile("^(?:" + source + ")")
+		if err != nil {
+			return nil, fmt.Errorf("%sinvalid pattern (%s): %s: %s", p.location(), p.Syntax, p.Pattern, err)
+		}
+		p.re = re
+
+		if !m.addLiteral(p) {
+			res = append(res, source)
+		}
+	}
+
+	if len(res) > 0 {
+		re, err := regexp.Compile("^(?:" + strings.Join(res, "|") + ")")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+
+	return m, nil
+}
+
+// addLiteral stores patterns that don't need a regexp in the radix trees.
+func (m *Matcher) addLiteral(p *Pattern) bool {
+	switch p.Syntax {
+	case Path, RelPath:
+		if p.Pattern == "." {
+			insert(m.rooted, "", matchPrefix)
+		} else {
+			insert(m.rooted, p.Pattern, matchPath)
+		}
+		return true
+	case Glob, RootGlob:
+		if p.Pattern == "" || !isLiteralGlob(p.Pattern) {
+			return false
+		}
+		insert(m.rooted, p.Pattern, m.literalGlobMode())
+		return true
+	case RelGlob:
+		if !isLiteralGlob(p.Pattern) {
+			return false
+		}
+		insert(m.unrooted, p.Pattern, m.literalGlobMode())
+		return true
+	case Regexp, RelRegexp:
+		prefix, exact, ok := literalPrefix(p.regexp(m.globSuffix))
+		if !ok {
+			return false
+		}
+		if exact {
+			insert(m.rooted, prefix, matchExact)
+		} else {
+			insert(m.rooted, prefix, matchPrefix)
+		}
+		return true
+	}
+	return false
+}
+
+// literalGlobMode returns how a glob without special characters matches.
+func (m *Matcher) literalGlobMode() int {
+	if m.globSuffix == fileGlobSuffix {
+		return matchExact
+	}
+	return matchPath
+}
+
+func insert(t *radix.Tree, key string, mode int) {
+	if old, ok := t.Get(key); ok {
+		mode |= old.(int)
+	}
+	t.Insert(key, mode)
+}
+
+// literalPrefix reports whether the regexp source, anchored at the start of the path,
+// matches exactly the paths starting with a literal string (or equal to it, when exact is set).
+func literalPrefix(source string) (prefix string, exact bool, ok bool) {
+	re, err := syntax.Parse(source, syntax.Perl)
+	if err != nil {
+		return "", false, false
+	}
+	re = re.Simplify()
+
+	var subs []*syntax.Regexp
+	switch re.Op {
+	case syntax.OpConcat:
+		subs = re.Sub
+	case syntax.OpLiteral:
+		subs = []*syntax.Regexp{re}
+	default:
+		return "", false, false
+	}
+	if subs[0].Op == syntax.OpBeginText {
+		subs = subs[1:]
+	}
+	if len(subs) > 0 && subs[len(subs)-1].Op == syntax.OpEndText {
+		exact = true
+		subs = subs[:len(subs)-1]
+	}
+
+	var b strings.Builder
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			return "", false, false
+		}
+		b.WriteString(string(sub.Rune))
+	}
+	if b.Len() == 0 {
+		return "", false, false
+	}
+
+	return b.String(), exact, true
+}
+
+// matchLiteral reports whether a literal of the tree matches path.
+func matchLiteral(t *radix.Tree, path string) bool {
+	matched := false
+	t.WalkPath(path, func(k string, v interface{}) bool {
+		mode := v.(int)
+		matched = mode&matchPrefix != 0 ||
+			(mode&(matchExact|matchPath) != 0 && len(k) == len(path)) ||
+			(mode&matchPath != 0 && path[len(k)] == '/')
+		return matched
+	})
+	return matched
+}
+
+// Match reports whether path matches any of the patterns.
+func (m *Matcher) Match(path string) bool {
+	if matchLiteral(m.rooted, path) {
+		return true
+	}
+
+	if m.unrooted.Len() > 0 {
+		for i := 0; i < len(path); i++ {
+			if (i == 0 || path[i-1] == '/') && matchLiteral(m.unrooted, path[i:]) {
+				return true
+			}
+		}
+	}
+
+	if m.re != nil && m.re.MatchString(path) {
+		return true
+	}
+
+	for _, sub := range m.subs {
+		if strings.HasPrefix(path, sub.prefix) && sub.matcher.Match(path[len(sub.prefix):]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Explain returns the first pattern matching path, looking into subincluded files too.
+// It is much slower than Match and meant for debugging.
+func (m *Matcher) Explain(path string) (*Pattern, bool) {
+	for i := range m.pats {
+		p := &m.pats[i]
+		if p.re.MatchString(path) {
+			return p, true
+		}
+	}
+
+	for _, sub := range m.subs {
+		if strings.HasPrefix(path, sub.prefix) {
+			if p, ok := sub.matcher.Explain(path[len(sub.prefix):]); ok {
+				return p, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// String describes the matcher like Mercurial prints matchers,
+// with the regexp of its patterns and the matchers of subincluded files.
+func (m *Matcher) String() string {
+	if len(m.pats) == 0 && len(m.subs) == 0 {
+		return "<nevermatcher>"
+	}
+
+	var res []string
+	for i := range m.pats {
+		res = append(res, m.pats[i].regexp(m.globSuffix))
+	}
+	s := fmt.Sprintf("<includematcher includes='%s'", strings.Join(res, "|"))
+	for _, sub := range m.subs {
+		s += fmt.Sprintf(" subinclude='%s':%s", sub.prefix, sub.matcher)
+	}
+	return s + ">"
+}
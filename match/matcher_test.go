@@ -0,0 +1,183 @@
+package match
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		source string
+		prefix string
+		exact  bool
+		ok     bool
+	}{
+		{source: "^build/", prefix: "build/", ok: true},
+		{source: "^Makefile$", prefix: "Makefile", exact: true, ok: true},
+		{source: `^foo\.c`, prefix: "foo.c", ok: true},
+		{source: "build/", prefix: "build/", ok: true},
+		{source: ".*build/"},
+		{source: "^(?i)build/"},
+		{source: "^build/.*"},
+		{source: "^a|^b"},
+		{source: "^"},
+	}
+
+	for _, tt := range tests {
+		prefix, exact, ok := literalPrefix(tt.source)
+		if prefix != tt.prefix || exact != tt.exact || ok != tt.ok {
+			t.Errorf("literalPrefix(%q) = (%q, %v, %v), want (%q, %v, %v)", tt.source, prefix, exact, ok, tt.prefix, tt.exact, tt.ok)
+		}
+	}
+}
+
+func TestMatchSameAsEachPattern(t *testing.T) {
+	pats := []Pattern{
+		{Syntax: RelGlob, Pattern: "*.o"},
+		{Syntax: RelGlob, Pattern: "node_modules"},
+		{Syntax: RelGlob, Pattern: "{foo,bar}/**/*.tmp"},
+		{Syntax: RootGlob, Pattern: "vendor"},
+		{Syntax: RootGlob, Pattern: "gen/*.go"},
+		{Syntax: RelRegexp, Pattern: "^build/"},
+		{Syntax: RelRegexp, Pattern: "^build$"},
+		{Syntax: RelRegexp, Pattern: "^Makefile$"},
+		{Syntax: RelRegexp, Pattern: `\.orig$`},
+		{Syntax: RelRegexp, Pattern: "^dist"},
+		{Syntax: Regexp, Pattern: "tmp"},
+		{Syntax: Path, Pattern: "third_party/lib"},
+		{Syntax: RelPath, Pattern: "docs"},
+		{Syntax: RootFilesIn, Pattern: "logs"},
+	}
+	paths := []string{
+		"a.o", "src/a.o", "a.c", "node_modules", "x/node_modules/y.js", "foo/a/b.tmp", "bar/c.tmp",
+		"baz/c.tmp", "build", "build/x", "builder", "Makefile", "src/Makefile", "a.c.orig", "dist",
+		"distribution/a", "src/dist", "vendor/x", "src/vendor", "gen/a.go", "gen/sub/a.go", "tmp/x",
+		"src/tmp", "third_party/lib/a", "third_party/library", "docs", "src/docs", "logs/a.log",
+		"logs/old/a.log",
+	}
+
+	m, err := Compile(pats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		want := false
+		for i := range pats {
+			re := regexp.MustCompile("^(?:" + pats[i].regexp(ignoreGlobSuffix) + ")")
+			if re.MatchString(path) {
+				want = true
+			}
+		}
+		if got := m.Match(path); got != want {
+			t.Errorf("Match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestMatchKinds(t *testing.T) {
+	tests := []struct {
+		pat   Pattern
+		path  string
+		match bool
+	}{
+		{Pattern{Syntax: RelGlob, Pattern: "*.o"}, "src/a.o", true},
+		{Pattern{Syntax: RelGlob, Pattern: "*.o"}, "a.obj", false},
+		{Pattern{Syntax: RelGlob, Pattern: "out"}, "src/out/a", true},
+		{Pattern{Syntax: RootGlob, Pattern: "out"}, "src/out/a", false},
+		{Pattern{Syntax: RootGlob, Pattern: "out"}, "out/a", true},
+		{Pattern{Syntax: RelRegexp, Pattern: "tmp"}, "src/tmp/a", true},
+		{Pattern{Syntax: RelRegexp, Pattern: "^tmp"}, "src/tmp/a", false},
+		{Pattern{Syntax: Regexp, Pattern: "tmp"}, "src/tmp/a", false},
+		{Pattern{Syntax: Path, Pattern: "a/b"}, "a/b/c", true},
+		{Pattern{Syntax: Path, Pattern: "a/b"}, "a/bc", false},
+		{Pattern{Syntax: Path, Pattern: "."}, "anything", true},
+		{Pattern{Syntax: RootFilesIn, Pattern: "a"}, "a/b", true},
+		{Pattern{Syntax: RootFilesIn, Pattern: "a"}, "a/b/c", false},
+		{Pattern{Syntax: RootFilesIn, Pattern: "."}, "a", true},
+		{Pattern{Syntax: RootFilesIn, Pattern: "."}, "a/b", false},
+	}
+
+	for _, tt := range tests {
+		m, err := Compile([]Pattern{tt.pat})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Match(tt.path); got != tt.match {
+			t.Errorf("%s: Match(%q) = %v, want %v", &tt.pat, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestCompileInvalidRegexp(t *testing.T) {
+	_, err := Compile([]Pattern{{Syntax: RelRegexp, Pattern: "a(b", Source: ".hgignore", Lineno: 3}})
+	if err == nil {
+		t.Fatal("Compile() succeeded on an invalid regexp")
+	}
+	if want := ".hgignore:3: invalid pattern (relre): a(b"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Compile() error = %q, want prefix %q", err, want)
+	}
+}
+
+func TestCompileEmpty(t *testing.T) {
+	m, err := Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Match("anything") {
+		t.Error("empty matcher matched")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	pats := []Pattern{
+		{Syntax: RelGlob, Pattern: "*.o", Source: ".hgignore", Lineno: 2, Original: "*.o"},
+		{Syntax: RelRegexp, Pattern: "^build/", Source: ".hgignore", Lineno: 3, Original: "re:^build/"},
+		{Syntax: RootGlob, Pattern: "out", Source: ".hgignore", Lineno: 4, Original: "rootglob:out"},
+	}
+	m, err := Compile(pats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Explain reuses the regexps compiled once by Compile, literals included.
+	for _, p := range m.pats {
+		if p.re == nil {
+			t.Errorf("%s was not compiled", &p)
+		}
+	}
+
+	if p, ok := m.Explain("build/a.o"); !ok || p.Lineno != 2 {
+		t.Errorf("Explain(build/a.o) = %v, %v, want line 2", p, ok)
+	}
+	if p, ok := m.Explain("build/a.c"); !ok || p.Original != "re:^build/" {
+		t.Errorf("Explain(build/a.c) = %v, %v, want re:^build/", p, ok)
+	}
+	if p, ok := m.Explain("out/a.c"); !ok || p.Lineno != 4 {
+		t.Errorf("Explain(out/a.c) = %v, %v, want line 4", p, ok)
+	}
+	if p, ok := m.Explain("a.c"); ok {
+		t.Errorf("Explain(a.c) = %v, want no match", p)
+	}
+}
+
+func TestString(t *testing.T) {
+	m, err := Compile([]Pattern{{Syntax: RelGlob, Pattern: "*.o"}, {Syntax: RootGlob, Pattern: "out"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.subs = []subMatcher{{prefix: "web/", matcher: &Matcher{pats: []Pattern{{Syntax: RelRegexp, Pattern: "^dist"}}}}}
+
+	want := `<includematcher includes='.*\.o(?:/|$)|out(?:/|$)' subinclude='web/':<includematcher includes='^dist'>>`
+	if got := m.String(); got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	empty, err := Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := empty.String(); got != "<nevermatcher>" {
+		t.Errorf("String() = %s, want <nevermatcher>", got)
+	}
+}
@@ -0,0 +1,57 @@
+package match
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CanonPath returns name as a slash-separated path relative to root, "" for root itself.
+// A relative name is relative to cwd. root and cwd must be absolute.
+// Paths into the .hg directory are refused.
+func CanonPath(root, cwd, name string) (string, error) {
+	abs := name
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(cwd, abs)
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s not under root '%s'", name, root)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	// Like Mercurial's pathauditor, refuse paths into any .hg directory.
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		if part = strings.ToLower(part); part != ".hg" && part != ".hg." {
+			continue
+		}
+		if i == 0 {
+			return "", fmt.Errorf("path contains illegal component: %s", rel)
+		}
+		return "", fmt.Errorf("path '%s' is inside nested repo '%s'", rel, strings.Join(parts[:i], "/"))
+	}
+	return rel, nil
+}
+
+// PathTo returns the path of f relative to the directory cwd, both being
+// slash-separated paths relative to the repository root, "" for the root.
+// A trailing slash of f is kept.
+func PathTo(cwd, f string) string {
+	if cwd == "" {
+		return f
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(cwd), filepath.FromSlash(f))
+	if err != nil {
+		return f
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(f, "/") && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+	return rel
+}
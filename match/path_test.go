@@ -0,0 +1,50 @@
+package match
+
+import "testing"
+
+func TestCanonPath(t *testing.T) {
+	tests := []struct {
+		cwd, name, want string
+		ok              bool
+	}{
+		{"/repo", "a/b", "a/b", true},
+		{"/repo/a", "b", "a/b", true},
+		{"/repo/a", "../c", "c", true},
+		{"/repo/a", "/repo/c/d", "c/d", true},
+		{"/repo", ".", "", true},
+		{"/repo", "../x", "", false},
+		{"/repo/a", "/repository/x", "", false},
+		{"/repo", ".hg/hgrc", "", false},
+		{"/repo", ".HG/hgrc", "", false},
+		{"/repo", "sub/.hg/store", "", false},
+		{"/repo/sub", ".hg.", "", false},
+		{"/repo", "sub/.hgignore", "sub/.hgignore", true},
+	}
+
+	for _, tt := range tests {
+		got, err := CanonPath("/repo", tt.cwd, tt.name)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("CanonPath(/repo, %s, %s) = %q, %v, want %q", tt.cwd, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestPathTo(t *testing.T) {
+	tests := []struct {
+		cwd, f, want string
+	}{
+		{"", "a/b", "a/b"},
+		{"a", "a/b", "b"},
+		{"a/b", "c", "../../c"},
+		{"a/b", "a/c/d", "../c/d"},
+		{"a", "a", "."},
+		{"a/b", "a/c/", "../c/"},
+		{"a/b", "a/b/", "./"},
+	}
+
+	for _, tt := range tests {
+		if got := PathTo(tt.cwd, tt.f); got != tt.want {
+			t.Errorf("PathTo(%q, %q) = %q, want %q", tt.cwd, tt.f, got, tt.want)
+		}
+	}
+}
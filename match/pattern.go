@@ -0,0 +1,209 @@
+// Package match implements Mercurial file patterns, as used by .hgignore.
+package match
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Syntax is the kind of a pattern, written as a "kind:" prefix.
+type Syntax int
+
+// Syntax types
+const (
+	// Glob is a shell-style glob rooted at the current directory.
+	Glob Syntax = iota
+	// Regexp is a regular expression rooted at the repository root.
+	Regexp
+	// RelGlob is a glob matching at any directory depth.
+	RelGlob
+	// RelRegexp is a regular expression matching anywhere in the path.
+	RelRegexp
+	// RootGlob is a glob rooted at the repository root.
+	RootGlob
+	// Path is a file or directory path relative to the repository root.
+	Path
+	// RelPath is a file or directory path relative to the current directory.
+	RelPath
+	// RootFilesIn matches the files of a directory, not its subdirectories.
+	RootFilesIn
+	// Include reads the patterns of another pattern file.
+	Include
+	// SubInclude reads the patterns of another pattern file,
+	// applying them to the directory of that file.
+	SubInclude
+	// Set is a fileset expression, see Fileset.
+	Set
+)
+
+var syntaxNames = map[Syntax]string{
+	Glob:        "glob",
+	Regexp:      "re",
+	RelGlob:     "relglob",
+	RelRegexp:   "relre",
+	RootGlob:    "rootglob",
+	Path:        "path",
+	RelPath:     "relpath",
+	RootFilesIn: "rootfilesin",
+	Include:     "include",
+	SubInclude:  "subinclude",
+	Set:         "set",
+}
+
+func (s Syntax) String() string {
+	return syntaxNames[s]
+}
+
+// Pattern is a single file pattern.
+type Pattern struct {
+	Syntax  Syntax
+	Pattern string
+
+	// Source, Lineno and Original locate the pattern in a pattern file.
+	Source   string
+	Lineno   int
+	Original string
+
+	// re is the anchored regexp of the pattern alone, set by Compile for Explain.
+	re *regexp.Regexp
+}
+
+func (p *Pattern) String() string {
+	return p.Syntax.String() + ":" + p.Pattern
+}
+
+// normalize cleans up path-like patterns the way Mercurial's util.normpath does.
+func (p *Pattern) normalize() {
+	switch p.Syntax {
+	case RelGlob, RootGlob, Path, RelPath, RootFilesIn, Include, SubInclude:
+		p.Pattern = path.Clean(p.Pattern)
+	}
+}
+
+// location returns "file:line: " for patterns read from a pattern file.
+func (p *Pattern) location() string {
+	if p.Source == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", p.Source, p.Lineno)
+}
+
+// regexp returns the regular expression source the pattern matches with.
+// Matching is anchored at the start of the path. globSuffix is appended to globs.
+//
+// Mechanical translation of match._regex.
+func (p *Pattern) regexp(globSuffix string) string {
+	pat := p.Pattern
+	switch p.Syntax {
+	case Regexp:
+		return pat
+	case Path, RelPath:
+		if pat == "" || pat == "." {
+			return ""
+		}
+		return regexp.QuoteMeta(pat) + "(?:/|$)"
+	case RootFilesIn:
+		escaped := ""
+		if pat != "." && pat != "" {
+			// Pattern is a directory name.
+			escaped = regexp.QuoteMeta(pat) + "/"
+		}
+		// Anything after the pattern must be a non-directory.
+		return escaped + "[^/]+$"
+	case RelGlob:
+		re := globre(pat)
+		if strings.HasPrefix(re, "[^/]*") {
+			// *XYZ is the same as **XYZ here and reads better.
+			return ".*" + re[len("[^/]*"):] + globSuffix
+		}
+		return "(?:|.*/)" + re + globSuffix
+	case RelRegexp:
+		if strings.HasPrefix(pat, "^") {
+			return pat
+		}
+		return ".*" + pat
+	default: // Glob, RootGlob
+		if pat == "" {
+			return ""
+		}
+		return globre(pat) + globSuffix
+	}
+}
+
+// isLiteralGlob reports whether a glob has no special characters.
+func isLiteralGlob(s string) bool {
+	return !strings.ContainsAny(s, "*?[{},\\")
+}
+
+// Mechanical translation of match._globre:
+func globre(s string) string {
+	i := 0
+	n := len(s)
+	group := 0
+	var res bytes.Buffer
+
+	for i < n {
+		c := s[i : i+1]
+		i++
+
+		if c != "*" && c != "?" && c != "[" && c != "{" && c != "}" && c != "," && c != "\\" {
+			res.WriteString(regexp.QuoteMeta(c))
+		} else if c == "*" {
+			if i < n && s[i:i+1] == "*" {
+				i++
+				if i < n && s[i:i+1] == "/" {
+					i++
+					res.WriteString("(?:.*/)?")
+				} else {
+					res.WriteString(".*")
+				}
+			} else {
+				res.WriteString("[^/]*")
+			}
+		} else if c == "?" {
+			res.WriteString(".")
+		} else if c == "[" {
+			j := i
+			if j < n && (s[j:j+1] == "!" || s[j:j+1] == "]") {
+				j++
+			}
+			for j < n && s[j:j+1] != "]" {
+				j++
+			}
+			if j >= n {
+				res.WriteString("\\[")
+			} else {
+				stuff := strings.Replace(s[i:j], "\\", "\\\\", -1)
+				i = j + 1
+				if stuff[0:1] == "!" {
+					stuff = "^" + stuff[1:]
+				} else if stuff[0:1] == "^" {
+					stuff = "\\" + stuff
+				}
+				res.WriteString("[" + stuff + "]")
+			}
+		} else if c == "{" {
+			group++
+			res.WriteString("(?:")
+		} else if c == "}" && group > 0 {
+			res.WriteString(")")
+			group--
+		} else if c == "," && group > 0 {
+			res.WriteString("|")
+		} else if c == "\\" {
+			if i < n {
+				res.WriteString(regexp.QuoteMeta(s[i : i+1]))
+				i++
+			} else {
+				res.WriteString(regexp.QuoteMeta(c))
+			}
+		} else {
+			res.WriteString(regexp.QuoteMeta(c))
+		}
+	}
+
+	return res.String()
+}
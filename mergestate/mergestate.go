@@ -0,0 +1,284 @@
+// Package mergestate reads the state of an unfinished merge, .hg/merge/state2.
+//
+// The file is a sequence of records: a one-byte type, a big-endian uint32 length
+// and that many bytes of data. Fields inside a record are separated by NUL bytes.
+//
+// Source: mercurial/mergestate.py:_readrecordsv2()
+package mergestate
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sashka/hgo/dirstate"
+)
+
+// Record types.
+const (
+	RecordLocal                = 'L'
+	RecordOther                = 'O'
+	RecordMerged               = 'F'
+	RecordChangeDeleteConflict = 'C'
+	RecordPathConflict         = 'P'
+	RecordFileValues           = 'f'
+	RecordLabels               = 'l'
+	RecordOverride             = 't'
+	RecordUnsupportedMandatory = 'X'
+	RecordUnsupportedAdvisory  = 'x'
+
+	// Written by older versions only.
+	RecordResolvedOther    = 'R'
+	RecordMergeDriverMerge = 'D'
+)
+
+// FileState is the resolution state of a conflicting file.
+type FileState string
+
+const (
+	Unresolved     FileState = "u"
+	Resolved       FileState = "r"
+	UnresolvedPath FileState = "pu"
+	ResolvedPath   FileState = "pr"
+
+	// DriverResolved was left by merge drivers, which Mercurial no longer has.
+	DriverResolved FileState = "d"
+
+	// mergedOther, only found in R records, marks a file taken from the other
+	// side rather than a conflict.
+	mergedOther FileState = "o"
+)
+
+// File is a file with a conflict.
+type File struct {
+	Path  string
+	State FileState
+
+	// Content conflicts (F and C records) name the versions taking part in the merge.
+	// Hash names the backup of the local version in .hg/merge.
+	Hash         string
+	LocalPath    string
+	AncestorPath string
+	AncestorNode string
+	OtherPath    string
+	OtherNode    string
+	Flags        string
+
+	// ChangeDelete is set for conflicts between a change and a deletion (C records).
+	ChangeDelete bool
+
+	// Path conflicts (P records) name where the file was moved and which side it came from.
+	RenamedPath string
+	Origin      string
+
+	// Extras are the values stored for the file by f records, such as "ancestorlinknode".
+	Extras map[string]string
+}
+
+// MergeState is the parsed content of .hg/merge/state2.
+type MergeState struct {
+	Local dirstate.Node
+	Other dirstate.Node
+
+	// Labels name the local, other and, optionally, base side of the merge.
+	Labels []string
+
+	// Files maps paths to their conflicts.
+	Files map[string]*File
+}
+
+var (
+	// ErrTruncated is returned when the merge state ends in the middle of a record.
+	ErrTruncated = errors.New("truncated merge state")
+	// ErrCorrupt is returned when the merge state contains an invalid record.
+	ErrCorrupt = errors.New("corrupt merge state")
+)
+
+// UnsupportedRecordsError is returned for record types that must be understood
+// to use the merge state, written by a newer Mercurial.
+type UnsupportedRecordsError struct {
+	Types []string
+}
+
+func (e *UnsupportedRecordsError) Error() string {
+	return "unsupported merge state records: " + strings.Join(e.Types, ", ")
+}
+
+// Active reports whether a merge is in progress.
+func (ms *MergeState) Active() bool {
+	return ms.Local != dirstate.NullNode || len(ms.Files) > 0
+}
+
+// Paths returns the paths of the conflicting files, sorted.
+// Files with extras only are not conflicts.
+func (ms *MergeState) Paths() []string {
+	paths := make([]string, 0, len(ms.Files))
+	for p, f := range ms.Files {
+		if f.State != "" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Unresolved returns the paths of the conflicts not resolved yet, sorted.
+func (ms *MergeState) Unresolved() []string {
+	var paths []string
+	for _, p := range ms.Paths() {
+		if s := ms.Files[p].State; s == Unresolved || s == UnresolvedPath {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Parse reads a merge state in the v2 record format.
+//
+// Lower-case record types are advisory and skipped when unknown,
+// unknown upper-case ones make Parse fail with an *UnsupportedRecordsError.
+func Parse(data []byte) (*MergeState, error) {
+	ms := &MergeState{Files: make(map[string]*File)}
+	var unsupported []string
+
+	for off := 0; off < len(data); {
+		if len(data)-off < 5 {
+			return nil, ErrTruncated
+		}
+		rtype := data[off]
+		length := int(binary.BigEndian.Uint32(data[off+1 : off+5]))
+		off += 5
+		if length < 0 || len(data)-off < length {
+			return nil, ErrTruncated
+		}
+		record := string(data[off : off+length])
+		off += length
+
+		// An override record wraps a record of any type, so that old
+		// versions which don't know it skip it as advisory.
+		if rtype == RecordOverride {
+			if record == "" {
+				return nil, ErrCorrupt
+			}
+			rtype, record = record[0], record[1:]
+		}
+
+		if err := ms.addRecord(rtype, record); err == errUnknownRecord {
+			if rtype >= 'A' && rtype <= 'Z' {
+				unsupported = append(unsupported, string(rtype))
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return nil, &UnsupportedRecordsError{Types: unsupported}
+	}
+	return ms, nil
+}
+
+var errUnknownRecord = errors.New("unknown merge state record")
+
+func (ms *MergeState) addRecord(rtype byte, record string) error {
+	switch rtype {
+	case RecordLocal, RecordOther:
+		node, err := hex.DecodeString(record)
+		if err != nil || len(node) != len(dirstate.NullNode) {
+			return ErrCorrupt
+		}
+		if rtype == RecordLocal {
+			copy(ms.Local[:], node)
+		} else {
+			copy(ms.Other[:], node)
+		}
+
+	case RecordMerged, RecordChangeDeleteConflict:
+		// path, state, hash, local path, ancestor path, ancestor node, other path, other node, flags.
+		// Fields added by newer versions are ignored.
+		fields := strings.Split(record, "\x00")
+		if len(fields) < 9 {
+			return ErrCorrupt
+		}
+		f := ms.file(fields[0])
+		f.State = FileState(fields[1])
+		f.Hash, f.LocalPath = fields[2], fields[3]
+		f.AncestorPath, f.AncestorNode = fields[4], fields[5]
+		f.OtherPath, f.OtherNode = fields[6], fields[7]
+		f.Flags = fields[8]
+		f.ChangeDelete = rtype == RecordChangeDeleteConflict
+
+	case RecordResolvedOther, RecordMergeDriverMerge:
+		// path, state, then the fields of an F record, which are not needed to list the file.
+		fields := strings.Split(record, "\x00")
+		if len(fields) < 2 {
+			return ErrCorrupt
+		}
+		f := ms.file(fields[0])
+		if FileState(fields[1]) == mergedOther {
+			f.Extras["filenode-source"] = "other"
+		} else {
+			f.State = FileState(fields[1])
+		}
+
+	case RecordPathConflict:
+		// path, state, renamed path, origin
+		fields := strings.Split(record, "\x00")
+		if len(fields) != 4 {
+			return ErrCorrupt
+		}
+		f := ms.file(fields[0])
+		f.State = FileState(fields[1])
+		f.RenamedPath, f.Origin = fields[2], fields[3]
+
+	case RecordFileValues:
+		// path, then key and value pairs
+		fields := strings.Split(record, "\x00")
+		if len(fields)%2 != 1 {
+			return ErrCorrupt
+		}
+		f := ms.file(fields[0])
+		for i := 1; i < len(fields); i += 2 {
+			f.Extras[fields[i]] = fields[i+1]
+		}
+
+	case RecordLabels:
+		ms.Labels = strings.Split(record, "\x00")
+
+	default:
+		return errUnknownRecord
+	}
+	return nil
+}
+
+// file returns the conflict of path, adding it if needed.
+func (ms *MergeState) file(path string) *File {
+	f, ok := ms.Files[path]
+	if !ok {
+		f = &File{Path: path, Extras: make(map[string]string)}
+		ms.Files[path] = f
+	}
+	return f
+}
+
+// ReadFile parses the merge state file at path.
+// A missing file means no merge is in progress.
+func ReadFile(path string) (*MergeState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &MergeState{Files: make(map[string]*File)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ms, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ms, nil
+}
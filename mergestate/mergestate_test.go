@@ -0,0 +1,151 @@
+package mergestate
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func record(rtype byte, fields ...string) []byte {
+	data := strings.Join(fields, "\x00")
+	buf := make([]byte, 5, 5+len(data))
+	buf[0] = rtype
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+	return append(buf, data...)
+}
+
+const (
+	localHex = "1111111111111111111111111111111111111111"
+	otherHex = "2222222222222222222222222222222222222222"
+)
+
+func sampleMergeState() []byte {
+	var data []byte
+	data = append(data, record('L', localHex)...)
+	data = append(data, record('O', otherHex)...)
+	data = append(data, record('F', "a.txt", "u", "ab12", "a.txt", "a.txt", "3333333333333333333333333333333333333333", "a.txt", "4444444444444444444444444444444444444444", "")...)
+	data = append(data, record('C', "b.txt", "r", "cd34", "b.txt", "b.txt", "3333333333333333333333333333333333333333", "b.txt", "0000000000000000000000000000000000000000", "x")...)
+	data = append(data, record('P', "c.txt", "pu", "c.txt~other", "r")...)
+	data = append(data, record('f', "a.txt", "ancestorlinknode", "5555555555555555555555555555555555555555", "merged", "yes")...)
+	data = append(data, record('l', "working copy", "merge rev")...)
+	data = append(data, record('y', "advisory records are skipped")...)
+	return data
+}
+
+func TestParse(t *testing.T) {
+	ms, err := Parse(sampleMergeState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ms.Local.String() != localHex || ms.Other.String() != otherHex {
+		t.Errorf("Local, Other = %s, %s", ms.Local, ms.Other)
+	}
+	if !ms.Active() {
+		t.Error("merge state is not active")
+	}
+	if want := []string{"working copy", "merge rev"}; !reflect.DeepEqual(ms.Labels, want) {
+		t.Errorf("Labels = %q, want %q", ms.Labels, want)
+	}
+	if want := []string{"a.txt", "b.txt", "c.txt"}; !reflect.DeepEqual(ms.Paths(), want) {
+		t.Errorf("Paths() = %q, want %q", ms.Paths(), want)
+	}
+	if want := []string{"a.txt", "c.txt"}; !reflect.DeepEqual(ms.Unresolved(), want) {
+		t.Errorf("Unresolved() = %q, want %q", ms.Unresolved(), want)
+	}
+
+	a := ms.Files["a.txt"]
+	if a.State != Unresolved || a.Hash != "ab12" || a.OtherNode != "4444444444444444444444444444444444444444" || a.ChangeDelete {
+		t.Errorf("a.txt = %+v", a)
+	}
+	if want := map[string]string{"ancestorlinknode": "5555555555555555555555555555555555555555", "merged": "yes"}; !reflect.DeepEqual(a.Extras, want) {
+		t.Errorf("a.txt extras = %v, want %v", a.Extras, want)
+	}
+	if b := ms.Files["b.txt"]; b.State != Resolved || !b.ChangeDelete || b.Flags != "x" {
+		t.Errorf("b.txt = %+v", b)
+	}
+	if c := ms.Files["c.txt"]; c.State != UnresolvedPath || c.RenamedPath != "c.txt~other" || c.Origin != "r" {
+		t.Errorf("c.txt = %+v", c)
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	ms, err := Parse(record('t', "L"+localHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Local.String() != localHex {
+		t.Errorf("Local = %s, want %s", ms.Local, localHex)
+	}
+}
+
+func TestParseLegacyRecords(t *testing.T) {
+	var data []byte
+	data = append(data, record('L', localHex)...)
+	// F records may have more fields than the ones read.
+	data = append(data, record('F', "a.txt", "r", "ab12", "a.txt", "a.txt", "3333333333333333333333333333333333333333", "a.txt", "4444444444444444444444444444444444444444", "", "extra")...)
+	data = append(data, record('D', "d.txt", "d", "ef56", "d.txt", "d.txt", "3333333333333333333333333333333333333333", "d.txt", "4444444444444444444444444444444444444444", "")...)
+	data = append(data, record('R', "o.txt", "o", "0000000000000000000000000000000000000000", "0000000000000000000000000000000000000000")...)
+	data = append(data, record('f', "x.txt", "filenode-source", "other")...)
+
+	ms, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files taken from the other side and files with extras only are not conflicts.
+	if want := []string{"a.txt", "d.txt"}; !reflect.DeepEqual(ms.Paths(), want) {
+		t.Errorf("Paths() = %q, want %q", ms.Paths(), want)
+	}
+	if len(ms.Unresolved()) != 0 {
+		t.Errorf("Unresolved() = %q, want none", ms.Unresolved())
+	}
+	if a := ms.Files["a.txt"]; a.State != Resolved || a.Flags != "" {
+		t.Errorf("a.txt = %+v", a)
+	}
+	if d := ms.Files["d.txt"]; d.State != DriverResolved {
+		t.Errorf("d.txt = %+v", d)
+	}
+	if o := ms.Files["o.txt"]; o.Extras["filenode-source"] != "other" {
+		t.Errorf("o.txt extras = %v, want filenode-source=other", o.Extras)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"truncated header", []byte{'L', 0, 0}, ErrTruncated},
+		{"truncated record", record('L', localHex)[:20], ErrTruncated},
+		{"bad node", record('L', "xyz"), ErrCorrupt},
+		{"short file record", record('F', "a.txt", "u"), ErrCorrupt},
+		{"short resolved record", record('R', "a.txt"), ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Parse() error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	_, err := Parse(append(record('X', "from the future"), record('L', localHex)...))
+	var unsupported *UnsupportedRecordsError
+	if !errors.As(err, &unsupported) || !reflect.DeepEqual(unsupported.Types, []string{"X"}) {
+		t.Errorf("Parse() with an X record: error = %v", err)
+	}
+}
+
+func TestReadFileMissing(t *testing.T) {
+	ms, err := ReadFile(filepath.Join(os.TempDir(), "no-such-mergestate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Active() {
+		t.Error("missing merge state is active")
+	}
+}
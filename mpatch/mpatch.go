@@ -0,0 +1,217 @@
+// Package mpatch applies Mercurial binary deltas.
+//
+// A delta is a sequence of hunks, each replacing text[start:end] with the hunk data:
+//
+//	<4-byte start><4-byte end><4-byte data length><data>
+//
+// Hunks are sorted and don't overlap. A chain of deltas is folded into a single
+// list of hunks against the original text before anything is copied, so that
+// rebuilding a revision costs about the size of the result rather than the
+// size of the result times the length of the chain.
+//
+// Source: mercurial/mpatch.c
+package mpatch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrCannotDecode is returned for deltas that are not a sequence of valid hunks.
+	ErrCannotDecode = errors.New("mpatch: patch cannot be decoded")
+	// ErrInvalid is returned for hunks that don't fit the text they apply to.
+	ErrInvalid = errors.New("mpatch: invalid patch")
+)
+
+const hunkHeaderSize = 12
+
+// frag is a hunk: text[start:end] is replaced with data.
+type frag struct {
+	start, end int
+	data       []byte
+}
+
+// Patches applies deltas, in order, to text.
+func Patches(text []byte, deltas [][]byte) ([]byte, error) {
+	if len(deltas) == 0 {
+		return text, nil
+	}
+	frags, err := fold(deltas)
+	if err != nil {
+		return nil, err
+	}
+	return apply(text, frags)
+}
+
+// Patch applies a single delta to text.
+func Patch(text, delta []byte) ([]byte, error) {
+	return Patches(text, [][]byte{delta})
+}
+
+// PatchedSize returns the size of a text of size orig once delta is applied to it.
+func PatchedSize(orig int, delta []byte) (int, error) {
+	frags, err := decode(delta)
+	if err != nil {
+		return 0, err
+	}
+	return patchedSize(orig, frags)
+}
+
+// decode parses the hunks of a delta.
+func decode(delta []byte) ([]frag, error) {
+	frags := make([]frag, 0, len(delta)/hunkHeaderSize)
+	last := 0
+	for pos := 0; pos < len(delta); {
+		if len(delta)-pos < hunkHeaderSize {
+			return nil, ErrCannotDecode
+		}
+		start := int(int32(binary.BigEndian.Uint32(delta[pos:])))
+		end := int(int32(binary.BigEndian.Uint32(delta[pos+4:])))
+		length := int(int32(binary.BigEndian.Uint32(delta[pos+8:])))
+		pos += hunkHeaderSize
+		if start < last || end < start || length < 0 || length > len(delta)-pos {
+			return nil, ErrCannotDecode
+		}
+		frags = append(frags, frag{start: start, end: end, data: delta[pos : pos+length]})
+		pos += length
+		last = end
+	}
+	return frags, nil
+}
+
+// fold combines a chain of deltas into the hunks of a single delta, halving the chain recursively.
+//
+// Mechanical translation of mpatch_fold.
+func fold(deltas [][]byte) ([]frag, error) {
+	if len(deltas) == 1 {
+		return decode(deltas[0])
+	}
+	half := len(deltas) / 2
+	a, err := fold(deltas[:half])
+	if err != nil {
+		return nil, err
+	}
+	b, err := fold(deltas[half:])
+	if err != nil {
+		return nil, err
+	}
+	return combine(a, b), nil
+}
+
+// combine returns the hunks of applying a, then b. The hunks of b are moved
+// to the coordinates of the text a applies to, and the hunks of a they replace are dropped.
+// a is consumed.
+//
+// Mechanical translation of combine in mpatch.c.
+func combine(a, b []frag) []frag {
+	c := make([]frag, 0, 2*(len(a)+len(b)))
+	offset := 0
+	for _, bh := range b {
+		// Keep the hunks of a before the hunk of b.
+		c, offset = gather(c, &a, bh.start, offset)
+		// Drop the ones it replaces.
+		post := discard(&a, bh.end, offset)
+		c = append(c, frag{start: bh.start - offset, end: bh.end - post, data: bh.data})
+		offset = post
+	}
+	return append(c, a...)
+}
+
+// gather moves the hunks of src that start before cut, a position in the patched text,
+// to dest, splitting the last one if it spans cut. offset is the difference between
+// positions in the patched text and in the original text, it is updated for the hunks moved.
+//
+// Mechanical translation of gather in mpatch.c.
+func gather(dest []frag, src *[]frag, cut, offset int) ([]frag, int) {
+	for len(*src) > 0 {
+		s := &(*src)[0]
+		if s.start+offset >= cut {
+			break
+		}
+		if s.start+offset+len(s.data) <= cut {
+			offset += s.start - s.end + len(s.data)
+			dest = append(dest, *s)
+			*src = (*src)[1:]
+			continue
+		}
+
+		c := cut - offset
+		if s.end < c {
+			c = s.end
+		}
+		l := cut - offset - s.start
+		if len(s.data) < l {
+			l = len(s.data)
+		}
+		offset += s.start + l - c
+		dest = append(dest, frag{start: s.start, end: c, data: s.data[:l]})
+		s.start = c
+		s.data = s.data[l:]
+		break
+	}
+	return dest, offset
+}
+
+// discard is gather without keeping the hunks.
+//
+// Mechanical translation of discard in mpatch.c.
+func discard(src *[]frag, cut, offset int) int {
+	for len(*src) > 0 {
+		s := &(*src)[0]
+		if s.start+offset >= cut {
+			break
+		}
+		if s.start+offset+len(s.data) <= cut {
+			offset += s.start - s.end + len(s.data)
+			*src = (*src)[1:]
+			continue
+		}
+
+		c := cut - offset
+		if s.end < c {
+			c = s.end
+		}
+		l := cut - offset - s.start
+		if len(s.data) < l {
+			l = len(s.data)
+		}
+		offset += s.start + l - c
+		s.start = c
+		s.data = s.data[l:]
+		break
+	}
+	return offset
+}
+
+// patchedSize returns the size of a text of size orig once frags are applied to it,
+// checking that they fit it.
+func patchedSize(orig int, frags []frag) (int, error) {
+	size := orig
+	last := 0
+	for _, f := range frags {
+		if f.start < last || f.end < f.start || f.end > orig {
+			return 0, ErrInvalid
+		}
+		size += len(f.data) - (f.end - f.start)
+		last = f.end
+	}
+	return size, nil
+}
+
+// apply builds the text of applying frags to text.
+func apply(text []byte, frags []frag) ([]byte, error) {
+	size, err := patchedSize(len(text), frags)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, size)
+	last := 0
+	for _, f := range frags {
+		out = append(out, text[last:f.start]...)
+		out = append(out, f.data...)
+		last = f.end
+	}
+	return append(out, text[last:]...), nil
+}
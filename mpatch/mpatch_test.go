@@ -0,0 +1,151 @@
+package mpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+type hunk struct {
+	start, end int
+	data       string
+}
+
+func encode(hunks ...hunk) []byte {
+	var buf bytes.Buffer
+	for _, h := range hunks {
+		binary.Write(&buf, binary.BigEndian, uint32(h.start))
+		binary.Write(&buf, binary.BigEndian, uint32(h.end))
+		binary.Write(&buf, binary.BigEndian, uint32(len(h.data)))
+		buf.WriteString(h.data)
+	}
+	return buf.Bytes()
+}
+
+// naivePatch applies the hunks of a valid delta one at a time.
+func naivePatch(text []byte, hunks []hunk) []byte {
+	var out []byte
+	last := 0
+	for _, h := range hunks {
+		out = append(out, text[last:h.start]...)
+		out = append(out, h.data...)
+		last = h.end
+	}
+	return append(out, text[last:]...)
+}
+
+// randomHunks returns sorted, non-overlapping hunks for a text of size n.
+func randomHunks(rnd *rand.Rand, n int) []hunk {
+	var hunks []hunk
+	pos := 0
+	for pos <= n && rnd.Intn(4) != 0 {
+		start := pos + rnd.Intn(n-pos+1)
+		end := start + rnd.Intn(n-start+1)
+		data := make([]byte, rnd.Intn(8))
+		for i := range data {
+			data[i] = byte('a' + rnd.Intn(26))
+		}
+		hunks = append(hunks, hunk{start, end, string(data)})
+		pos = end
+		if pos == n {
+			break
+		}
+	}
+	return hunks
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		text  string
+		delta []byte
+		want  string
+	}{
+		{"abc", encode(hunk{1, 2, "XY"}), "aXYc"},
+		{"abc", encode(hunk{0, 0, ">"}, hunk{3, 3, "<"}), ">abc<"},
+		{"abc", encode(hunk{0, 3, ""}), ""},
+		{"abc", nil, "abc"},
+		{"", encode(hunk{0, 0, "new"}), "new"},
+	}
+
+	for _, tt := range tests {
+		got, err := Patch([]byte(tt.text), tt.delta)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Patch(%q, %q) = %q, %v, want %q", tt.text, tt.delta, got, err, tt.want)
+		}
+	}
+}
+
+func TestPatchesMatchSequentialApplication(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		base := make([]byte, rnd.Intn(40))
+		for j := range base {
+			base[j] = byte('A' + rnd.Intn(26))
+		}
+
+		text := base
+		var deltas [][]byte
+		for n := rnd.Intn(20); n > 0; n-- {
+			hunks := randomHunks(rnd, len(text))
+			deltas = append(deltas, encode(hunks...))
+			text = naivePatch(text, hunks)
+		}
+
+		got, err := Patches(base, deltas)
+		if err != nil || !bytes.Equal(got, text) {
+			t.Fatalf("Patches(%q, %d deltas) = %q, %v, want %q", base, len(deltas), got, err, text)
+		}
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		deltas [][]byte
+		err    error
+	}{
+		{"truncated header", "abc", [][]byte{encode(hunk{0, 1, "x"})[:8]}, ErrCannotDecode},
+		{"truncated data", "abc", [][]byte{encode(hunk{0, 1, "xyz"})[:14]}, ErrCannotDecode},
+		{"end before start", "abc", [][]byte{encode(hunk{2, 1, ""})}, ErrCannotDecode},
+		{"out of order", "abc", [][]byte{encode(hunk{2, 3, ""}, hunk{0, 1, ""})}, ErrCannotDecode},
+		{"negative start", "abc", [][]byte{encode(hunk{-1, 1, ""})}, ErrCannotDecode},
+		{"past the end", "abc", [][]byte{encode(hunk{0, 4, ""})}, ErrInvalid},
+		{"past the end of the patched text", "abc", [][]byte{encode(hunk{0, 3, "x"}), encode(hunk{0, 2, ""})}, ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		if _, err := Patches([]byte(tt.text), tt.deltas); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPatchedSize(t *testing.T) {
+	size, err := PatchedSize(10, encode(hunk{0, 4, "ab"}, hunk{6, 6, "xyz"}))
+	if err != nil || size != 11 {
+		t.Errorf("PatchedSize = %d, %v, want 11", size, err)
+	}
+}
+
+func BenchmarkPatchesLongChain(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	base := bytes.Repeat([]byte("line of text\n"), 10000)
+	text := base
+	var deltas [][]byte
+	for i := 0; i < 1000; i++ {
+		start := rnd.Intn(len(text) - 13)
+		hunks := []hunk{{start, start + 13, "changed line\n"}}
+		deltas = append(deltas, encode(hunks...))
+		text = naivePatch(text, hunks)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Patches(base, deltas); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"github.com/sashka/hgo/config"
+)
+
+// Config returns the configuration of the repository: the system-wide and per-user
+// configuration files followed by .hg/hgrc. It is read once.
+func (r *Repo) Config() (*config.Config, error) {
+	if r.config != nil {
+		return r.config, nil
+	}
+
+	cfg := config.New()
+	for _, path := range config.Paths() {
+		if err := cfg.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ReadFile(r.Join("hgrc")); err != nil {
+		return nil, err
+	}
+
+	r.config = cfg
+	return cfg, nil
+}
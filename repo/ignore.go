@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashka/hgo/config"
+	"github.com/sashka/hgo/match"
+)
+
+// IgnoreFiles returns the pattern files of the repository: .hgignore at the root,
+// if any, then the files listed by the ui.ignore and ui.ignore.* settings.
+// Relative settings are relative to the root.
+func (r *Repo) IgnoreFiles() ([]string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	hgignore := filepath.Join(r.RootDir, ".hgignore")
+	if _, err := os.Stat(hgignore); err == nil {
+		files = append(files, hgignore)
+	}
+	for _, item := range cfg.Items("ui") {
+		if item.Name == "ignore" || strings.HasPrefix(item.Name, "ignore.") {
+			path := config.ExpandPath(item.Value)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(r.RootDir, path)
+			}
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
+
+// Ignore returns the matcher of ignored files, built from IgnoreFiles.
+func (r *Repo) Ignore() (*match.Matcher, error) {
+	files, err := r.IgnoreFiles()
+	if err != nil {
+		return nil, err
+	}
+	return match.Load(r.RootDir, files)
+}
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned when a lock is held by another process.
+var ErrLocked = errors.New("lock held by another process")
+
+// Lock is a lock on the working directory or the store of a repository.
+//
+// Like Mercurial, hgo creates the lock as a symlink whose target is "<hostname>:<pid>".
+type Lock struct {
+	path string
+}
+
+// TryWLock takes the working directory lock (.hg/wlock) without waiting for it.
+func (r *Repo) TryWLock() (*Lock, error) {
+	return tryLock(r.Join("wlock"))
+}
+
+func tryLock(path string) (*Lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	info := fmt.Sprintf("%s:%d", host, os.Getpid())
+
+	if err := os.Symlink(info, path); err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return &Lock{path: path}, nil
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sashka/hgo/revlog"
+)
+
+// Lookup resolves a revision identifier to a changeset node.
+//
+// Accepted are ".", the first parent of the working directory, "tip", "null",
+// revision numbers (negative ones count back from tip), full hex nodes and
+// unique hex node prefixes.
+func (r *Repo) Lookup(spec string) (revlog.Node, error) {
+	switch spec {
+	case "null":
+		return revlog.NullNode, nil
+	case ".":
+		ds, err := r.DirState()
+		if err != nil {
+			return revlog.NullNode, err
+		}
+		return revlog.Node(ds.Parents[0]), nil
+	}
+
+	cl, err := r.Changelog()
+	if err != nil {
+		return revlog.NullNode, err
+	}
+
+	if spec == "tip" {
+		return cl.Node(revlog.Rev(cl.Len() - 1))
+	}
+
+	if n, err := strconv.Atoi(spec); err == nil && strconv.Itoa(n) == spec {
+		if n < 0 {
+			n += cl.Len()
+		}
+		if n >= -1 && n < cl.Len() {
+			return cl.Node(revlog.Rev(n))
+		}
+	}
+
+	if isHexPrefix(spec) {
+		node, err := cl.PartialMatch(spec)
+		if err == nil {
+			return node, nil
+		}
+		if errors.Is(err, revlog.ErrAmbiguous) {
+			return revlog.NullNode, fmt.Errorf("ambiguous identifier '%s'", spec)
+		}
+	}
+
+	return revlog.NullNode, fmt.Errorf("unknown revision '%s'", spec)
+}
+
+// isHexPrefix reports whether s may be the beginning of a hex node.
+func isHexPrefix(s string) bool {
+	if s == "" || len(s) > 2*len(revlog.Node{}) {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdefABCDEF") == ""
+}
@@ -1,14 +1,45 @@
 package repo
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sashka/hgo/changelog"
+	"github.com/sashka/hgo/config"
+	"github.com/sashka/hgo/dirstate"
+	"github.com/sashka/hgo/mergestate"
+	"github.com/sashka/hgo/revlog"
+	"github.com/sashka/hgo/store"
+)
+
+// Requirement names hgo checks for.
+const (
+	DirstateV2Requirement = "dirstate-v2"
+	SharedRequirement     = "shared"
+	RelSharedRequirement  = "relshared"
+	ShareSafeRequirement  = "share-safe"
 )
 
 type Repo struct {
 	RootDir string
+
+	// Requirements is the set of features listed in .hg/requires and,
+	// for share-safe repositories, in the requires file of the store.
+	Requirements map[string]bool
+
+	// sharedDir is the .hg directory of the repository whose store this one shares,
+	// "" when the repository has its own store.
+	sharedDir string
+
+	config      *config.Config
+	store       *store.Store
+	changelog   *changelog.Changelog
+	manifestlog *revlog.Revlog
 }
 
 func Open(path string) (*Repo, error) {
@@ -20,9 +51,67 @@ func Open(path string) (*Repo, error) {
 		return nil, err
 	}
 
-	return &Repo{
-		RootDir: root,
-	}, nil
+	r := &Repo{RootDir: root}
+	if err := r.loadRequirements(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Join returns the path of a file inside the .hg directory.
+func (r *Repo) Join(elem ...string) string {
+	return filepath.Join(append([]string{r.RootDir, ".hg"}, elem...)...)
+}
+
+// DirState reads the dirstate in the format the repository requires.
+func (r *Repo) DirState() (*dirstate.DirState, error) {
+	if r.Requirements[DirstateV2Requirement] {
+		return dirstate.ReadFileV2(r.Join("dirstate"))
+	}
+	return dirstate.ReadFile(r.Join("dirstate"))
+}
+
+// MergeState reads the state of the merge in progress, empty when there is none.
+// Only the v2 format, merge/state2, is read.
+func (r *Repo) MergeState() (*mergestate.MergeState, error) {
+	return mergestate.ReadFile(r.Join("merge", "state2"))
+}
+
+// WriteDirState replaces the dirstate in the format the repository requires.
+// Entries whose mtime is ambiguous at the time of writing lose it, so that they
+// are never taken for clean on the strength of it.
+func (r *Repo) WriteDirState(ds *dirstate.DirState) error {
+	ds.ClearAmbiguousMtimes(time.Now())
+	if r.Requirements[DirstateV2Requirement] {
+		return dirstate.WriteFileV2(r.Join("dirstate"), ds)
+	}
+	return dirstate.WriteFile(r.Join("dirstate"), ds)
+}
+
+// readRequirements parses a requires file: one feature name per line.
+// A missing file means no requirements.
+func readRequirements(path string) (map[string]bool, error) {
+	reqs := make(map[string]bool)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return reqs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if s := strings.TrimSpace(scanner.Text()); s != "" {
+			reqs[s] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return reqs, nil
 }
 
 var ErrRepoNotFound = errors.New(".hg not found")
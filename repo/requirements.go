@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sashka/hgo/store"
+)
+
+// supportedRequirements are the features hgo knows how to read.
+// Notably missing are revlog-compression-zstd, treemanifest and exp-sparse.
+var supportedRequirements = map[string]bool{
+	"revlogv1":                 true,
+	store.StoreRequirement:     true,
+	store.FncacheRequirement:   true,
+	store.DotencodeRequirement: true,
+	"generaldelta":             true,
+	"sparserevlog":             true,
+	"persistent-nodemap":       true,
+	"bookmarksinstore":         true,
+	"internal-phase":           true,
+	"dirstate-tracked-key-v1":  true,
+	DirstateV2Requirement:      true,
+	SharedRequirement:          true,
+	RelSharedRequirement:       true,
+	ShareSafeRequirement:       true,
+}
+
+// RequirementError is returned when opening a repository that requires features hgo lacks.
+type RequirementError struct {
+	Missing []string
+}
+
+func (e *RequirementError) Error() string {
+	return "repository requires features unknown to this Mercurial: " + strings.Join(e.Missing, " ")
+}
+
+// Hint tells where to learn more about the missing features.
+func (e *RequirementError) Hint() string {
+	return "see https://mercurial-scm.org/wiki/MissingRequirement for more information"
+}
+
+// loadRequirements reads .hg/requires and, for share-safe repositories, the requires
+// file of the store, which may belong to another repository for shares.
+//
+// Simplified translation of localrepo.makelocalrepository.
+func (r *Repo) loadRequirements() error {
+	reqs, err := readRequirements(r.Join("requires"))
+	if err != nil {
+		return err
+	}
+
+	if reqs[SharedRequirement] || reqs[RelSharedRequirement] {
+		sharedDir, err := readSharedPath(r.Join(), reqs[RelSharedRequirement])
+		if err != nil {
+			return err
+		}
+		r.sharedDir = sharedDir
+	}
+
+	if reqs[ShareSafeRequirement] {
+		storeReqs, err := readRequirements(filepath.Join(r.storeBase(), "store", "requires"))
+		if err != nil {
+			return err
+		}
+		for req := range storeReqs {
+			reqs[req] = true
+		}
+	}
+
+	if err := checkRequirements(reqs); err != nil {
+		return err
+	}
+	r.Requirements = reqs
+	return nil
+}
+
+// storeBase is the .hg directory holding the store of the repository.
+func (r *Repo) storeBase() string {
+	if r.sharedDir != "" {
+		return r.sharedDir
+	}
+	return r.Join()
+}
+
+// readSharedPath reads .hg/sharedpath, the .hg directory of the repository a share uses
+// the store of. relative tells that it is relative to hgDir.
+func readSharedPath(hgDir string, relative bool) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(hgDir, "sharedpath"))
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimRight(string(data), "\n")
+	if relative {
+		path = filepath.Join(hgDir, path)
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", fmt.Errorf(".hg/sharedpath points to nonexistent directory %s", path)
+	}
+	return path, nil
+}
+
+// checkRequirements fails if a requirement is not supported.
+//
+// Mechanical translation of localrepo.ensurerequirementsrecognized.
+func checkRequirements(reqs map[string]bool) error {
+	var missing []string
+	for req := range reqs {
+		if supportedRequirements[req] {
+			continue
+		}
+		if r := []rune(req); len(r) == 0 || !(unicode.IsLetter(r[0]) || unicode.IsDigit(r[0])) {
+			return fmt.Errorf(".hg/requires file is corrupt")
+		}
+		missing = append(missing, req)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &RequirementError{Missing: missing}
+	}
+	return nil
+}
+
+// SortedRequirements returns the requirements of the repository in alphabetical order.
+func (r *Repo) SortedRequirements() []string {
+	reqs := make([]string, 0, len(r.Requirements))
+	for req := range r.Requirements {
+		reqs = append(reqs, req)
+	}
+	sort.Strings(reqs)
+	return reqs
+}
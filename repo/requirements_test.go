@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := join(root, name)
+		if err := os.MkdirAll(join(path, ".."), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenRequirements(t *testing.T) {
+	root, err := ioutil.TempDir("", "requirements-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"plain/.hg/requires":              "dotencode\nfncache\ngeneraldelta\nrevlogv1\nstore\n",
+		"safe/.hg/requires":               "dirstate-v2\nshare-safe\n",
+		"safe/.hg/store/requires":         "fncache\nrevlogv1\nstore\n",
+		"share/.hg/requires":              "relshared\nshare-safe\n",
+		"share/.hg/sharedpath":            "../../safe/.hg\n",
+		"unknown/.hg/requires":            "store\ntreemanifest\nrevlog-compression-zstd\n",
+		"unknownstore/.hg/requires":       "share-safe\n",
+		"unknownstore/.hg/store/requires": "exp-sparse\nstore\n",
+		"corrupt/.hg/requires":            "store\n-oops\n",
+		"badshare/.hg/requires":           "shared\n",
+		"badshare/.hg/sharedpath":         join(root, "nowhere", ".hg"),
+	})
+
+	tests := []struct {
+		dir     string
+		want    []string
+		missing []string
+		ok      bool
+	}{
+		{dir: "plain", want: []string{"dotencode", "fncache", "generaldelta", "revlogv1", "store"}, ok: true},
+		{dir: "safe", want: []string{"dirstate-v2", "fncache", "revlogv1", "share-safe", "store"}, ok: true},
+		{dir: "share", want: []string{"fncache", "relshared", "revlogv1", "share-safe", "store"}, ok: true},
+		{dir: "unknown", missing: []string{"revlog-compression-zstd", "treemanifest"}},
+		{dir: "unknownstore", missing: []string{"exp-sparse"}},
+		{dir: "corrupt"},
+		{dir: "badshare"},
+	}
+
+	for _, tt := range tests {
+		r, err := Open(join(root, tt.dir))
+		if tt.ok {
+			if err != nil {
+				t.Errorf("Open(%s): %v", tt.dir, err)
+			} else if got := r.SortedRequirements(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Open(%s) requirements = %q, want %q", tt.dir, got, tt.want)
+			}
+			continue
+		}
+
+		var reqErr *RequirementError
+		if err == nil {
+			t.Errorf("Open(%s) succeeded", tt.dir)
+		} else if tt.missing != nil && (!errors.As(err, &reqErr) || !reflect.DeepEqual(reqErr.Missing, tt.missing)) {
+			t.Errorf("Open(%s) error = %v, want missing %q", tt.dir, err, tt.missing)
+		} else if reqErr != nil && !strings.Contains(reqErr.Hint(), "https://mercurial-scm.org/wiki/MissingRequirement") {
+			t.Errorf("Open(%s) hint = %q, want the MissingRequirement wiki page", tt.dir, reqErr.Hint())
+		}
+	}
+
+	// A share reads the store of its source.
+	r, err := Open(join(root, "share"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := join(root, "safe", ".hg", "store"); r.Store().Dir != want {
+		t.Errorf("store of share = %s, want %s", r.Store().Dir, want)
+	}
+}
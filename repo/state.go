@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sashka/hgo/dirstate"
+)
+
+// UnfinishedState is an operation interrupted in the working directory.
+type UnfinishedState struct {
+	// Name is the command that was interrupted: "rebase", "histedit", "unshelve",
+	// "graft", "update" or "merge".
+	Name string
+
+	// StateFile is the file under .hg recording the operation, "" for a merge,
+	// which is recorded by the second parent of the working directory.
+	StateFile string
+
+	// Hint tells how to continue or abort the operation, one line per way.
+	Hint string
+}
+
+// unfinishedStates lists the operations that may be left unfinished, in the order
+// Mercurial checks them: merge comes last, as the others may leave merges behind.
+//
+// Source: mercurial/state.py:addunfinished() and its callers.
+var unfinishedStates = []UnfinishedState{
+	{Name: "rebase", StateFile: "rebasestate", Hint: continueHint("rebase", true)},
+	{Name: "histedit", StateFile: "histedit-state", Hint: continueHint("histedit", false)},
+	{Name: "unshelve", StateFile: "shelvedstate", Hint: continueHint("unshelve", false)},
+	{Name: "graft", StateFile: "graftstate", Hint: continueHint("graft", true)},
+	{Name: "update", StateFile: "updatestate", Hint: "To continue:    hg update ."},
+	{Name: "merge", Hint: "To continue:    hg commit\nTo abort:       hg merge --abort"},
+}
+
+// continueHint is the default hint of statecheck.statusmsg.
+func continueHint(name string, stop bool) string {
+	hint := fmt.Sprintf("To continue:    hg %s --continue\nTo abort:       hg %s --abort", name, name)
+	if stop {
+		hint += fmt.Sprintf("\nTo stop:        hg %s --stop", name)
+	}
+	return hint
+}
+
+// UnfinishedState returns the operation left unfinished in the working directory,
+// nil when there is none.
+func (r *Repo) UnfinishedState() (*UnfinishedState, error) {
+	for i := range unfinishedStates {
+		state := &unfinishedStates[i]
+		if state.StateFile == "" {
+			ds, err := r.DirState()
+			if err != nil {
+				return nil, err
+			}
+			if ds.Parents[1] != dirstate.NullNode {
+				return state, nil
+			}
+			continue
+		}
+
+		if _, err := os.Lstat(r.Join(state.StateFile)); err == nil {
+			return state, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
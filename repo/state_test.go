@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sashka/hgo/dirstate"
+)
+
+func TestUnfinishedState(t *testing.T) {
+	root := preparePlayground(t)
+	defer os.RemoveAll(root)
+
+	r, err := Open(join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(r.Join("dirstate"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := r.UnfinishedState()
+	if err != nil || state != nil {
+		t.Fatalf("UnfinishedState() = %v, %v, want nothing", state, err)
+	}
+
+	// A merge is recorded by the second parent.
+	ds := &dirstate.DirState{}
+	ds.Parents[1][0] = 1
+	if err := dirstate.WriteFile(r.Join("dirstate"), ds); err != nil {
+		t.Fatal(err)
+	}
+	state, err = r.UnfinishedState()
+	if err != nil || state == nil || state.Name != "merge" {
+		t.Fatalf("UnfinishedState() = %v, %v, want merge", state, err)
+	}
+
+	// A rebase leaves a merge behind, but is what needs continuing.
+	if err := ioutil.WriteFile(r.Join("rebasestate"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err = r.UnfinishedState()
+	if err != nil || state == nil || state.Name != "rebase" {
+		t.Fatalf("UnfinishedState() = %v, %v, want rebase", state, err)
+	}
+	if want := "To continue:    hg rebase --continue\nTo abort:       hg rebase --abort\nTo stop:        hg rebase --stop"; state.Hint != want {
+		t.Errorf("rebase hint = %q, want %q", state.Hint, want)
+	}
+}
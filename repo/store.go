@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"os"
+
+	"github.com/sashka/hgo/changelog"
+	"github.com/sashka/hgo/filelog"
+	"github.com/sashka/hgo/manifest"
+	"github.com/sashka/hgo/revlog"
+	"github.com/sashka/hgo/store"
+)
+
+// Store returns the store holding the revlogs of the repository.
+func (r *Repo) Store() *store.Store {
+	if r.store == nil {
+		r.store = store.New(r.storeBase(), r.Requirements)
+	}
+	return r.store
+}
+
+// Changelog opens the changelog of the repository.
+// When HG_PENDING is the root of the repository, as in hooks run during
+// a transaction, changesets not yet committed by the transaction are visible.
+func (r *Repo) Changelog() (*changelog.Changelog, error) {
+	if r.changelog == nil {
+		pending := os.Getenv("HG_PENDING") == r.RootDir
+		cl, err := changelog.Open(r.Store().Join("00changelog.i"), pending)
+		if err != nil {
+			return nil, err
+		}
+		r.changelog = cl
+	}
+	return r.changelog, nil
+}
+
+// Manifestlog opens the manifest revlog of the repository.
+func (r *Repo) Manifestlog() (*revlog.Revlog, error) {
+	if r.manifestlog == nil {
+		ml, err := revlog.Open(r.Store().Join("00manifest.i"))
+		if err != nil {
+			return nil, err
+		}
+		r.manifestlog = ml
+	}
+	return r.manifestlog, nil
+}
+
+// Filelog opens the filelog of a tracked file.
+func (r *Repo) Filelog(path string) (*filelog.Filelog, error) {
+	return filelog.Open(r.Store().FilelogPath(path))
+}
+
+// Changeset returns the changeset identified by spec, as accepted by Lookup.
+func (r *Repo) Changeset(spec string) (*changelog.Changeset, error) {
+	node, err := r.Lookup(spec)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := r.Changelog()
+	if err != nil {
+		return nil, err
+	}
+	return cl.ReadNode(node)
+}
+
+// Manifest returns the manifest of the changeset node.
+func (r *Repo) Manifest(node revlog.Node) (manifest.Manifest, error) {
+	if node == revlog.NullNode {
+		return manifest.Manifest{}, nil
+	}
+
+	cl, err := r.Changelog()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := cl.ReadNode(node)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Manifest == revlog.NullNode {
+		return manifest.Manifest{}, nil
+	}
+
+	ml, err := r.Manifestlog()
+	if err != nil {
+		return nil, err
+	}
+	mtext, err := ml.RevisionByNode(cs.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Parse(mtext)
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sashka/hgo/config"
+)
+
+// Subrepo is a subrepository of the working directory, listed in .hgsub.
+type Subrepo struct {
+	// Path is the slash-separated path of the subrepository relative to the root.
+	Path string
+	// Source is where the subrepository is cloned from.
+	Source string
+	// Kind is the version control system of the subrepository: "hg", "git" or "svn".
+	Kind string
+	// Revision is the revision recorded in .hgsubstate, "" when there is none.
+	Revision string
+}
+
+// Subrepos returns the subrepositories of the working directory, sorted by path.
+func (r *Repo) Subrepos() ([]Subrepo, error) {
+	hgsub, err := ioutil.ReadFile(filepath.Join(r.RootDir, ".hgsub"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	hgsubstate, err := ioutil.ReadFile(filepath.Join(r.RootDir, ".hgsubstate"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return parseSubstate(filepath.Join(r.RootDir, ".hgsub"), hgsub, hgsubstate)
+}
+
+// parseSubstate parses the contents of .hgsub, "path = [kind]source" lines,
+// and of .hgsubstate, "revision path" lines.
+// src names .hgsub in errors.
+//
+// Simplified translation of subrepoutil.state: [subpaths] remapping is not applied.
+func parseSubstate(src string, hgsub, hgsubstate []byte) ([]Subrepo, error) {
+	revs := make(map[string]string)
+	for i, l := range strings.Split(string(hgsubstate), "\n") {
+		l = strings.TrimLeft(strings.TrimRight(l, "\r"), " \t")
+		if l == "" {
+			continue
+		}
+		fields := strings.SplitN(l, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid subrepository revision specifier in '.hgsubstate' line %d", i+1)
+		}
+		revs[fields[1]] = fields[0]
+	}
+
+	cfg := config.New()
+	if err := cfg.Parse(src, hgsub); err != nil {
+		return nil, err
+	}
+
+	var subs []Subrepo
+	for _, item := range cfg.Items("") {
+		kind, source := "hg", item.Value
+		if strings.HasPrefix(source, "[") {
+			i := strings.IndexByte(source, ']')
+			if i < 0 {
+				return nil, fmt.Errorf("missing ] in subrepository source")
+			}
+			kind, source = source[1:i], strings.TrimLeft(source[i+1:], " \t")
+		}
+		subs = append(subs, Subrepo{
+			Path:     path.Clean(filepath.ToSlash(item.Name)),
+			Source:   strings.TrimSpace(source),
+			Kind:     kind,
+			Revision: revs[item.Name],
+		})
+	}
+
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Path < subs[j].Path })
+	return subs, nil
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSubstate(t *testing.T) {
+	hgsub := []byte(`libs/core = https://hg.example.com/core
+vendor/tool = [git]https://example.com/tool.git
+docs = [svn]  https://svn.example.com/docs
+`)
+	hgsubstate := []byte(`0123456789abcdef0123456789abcdef01234567 libs/core
+89abcdef0123456789abcdef0123456789abcdef vendor/tool
+`)
+
+	subs, err := parseSubstate(".hgsub", hgsub, hgsubstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Subrepo{
+		{Path: "docs", Source: "https://svn.example.com/docs", Kind: "svn"},
+		{Path: "libs/core", Source: "https://hg.example.com/core", Kind: "hg", Revision: "0123456789abcdef0123456789abcdef01234567"},
+		{Path: "vendor/tool", Source: "https://example.com/tool.git", Kind: "git", Revision: "89abcdef0123456789abcdef0123456789abcdef"},
+	}
+	if !reflect.DeepEqual(subs, want) {
+		t.Errorf("parseSubstate = %+v, want %+v", subs, want)
+	}
+}
+
+func TestParseSubstateErrors(t *testing.T) {
+	tests := []struct {
+		hgsub, hgsubstate string
+	}{
+		{"sub = [git https://example.com/sub.git\n", ""},
+		{"sub = sub\n", "0123456789abcdef0123456789abcdef01234567\n"},
+		{" sub = sub\n", ""},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseSubstate(".hgsub", []byte(tt.hgsub), []byte(tt.hgsubstate)); err == nil {
+			t.Errorf("parseSubstate(%q, %q) succeeded", tt.hgsub, tt.hgsubstate)
+		}
+	}
+}
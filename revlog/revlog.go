@@ -0,0 +1,457 @@
+// Package revlog reads Mercurial revlogs, the storage format of the
+// changelog, manifests and filelogs.
+//
+// Original Hg wiki page on RevlogNG: https://www.mercurial-scm.org/wiki/RevlogNG
+package revlog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sashka/hgo/mpatch"
+)
+
+// A revlog consists of an index file (".i") and an optional data file (".d").
+// The index is a sequence of 64-byte entries:
+//
+// 	<6-byte data offset><2-byte flags><4-byte compressed length><4-byte uncompressed length>
+// 	<4-byte base rev><4-byte link rev><4-byte parent 1 rev><4-byte parent 2 rev>
+// 	<32-byte node id (20-byte SHA-1 padded with zeros)>
+//
+// The first 4 bytes of the first entry are replaced with the revlog version and flags,
+// so the offset of revision 0 is always 0.
+//
+// If the revlog is inline, the data chunk of every revision immediately follows its index entry.
+// Otherwise chunks live in the ".d" file at their offset.
+//
+// Source: mercurial/revlogutils/constants.py, mercurial/pure/parsers.py
+
+// Rev is a revision number, local to a revlog.
+type Rev int
+
+// NullRev is the revision number of the null revision.
+const NullRev Rev = -1
+
+// Node is a 20-byte revision hash.
+type Node [20]byte
+
+// NullNode is the hash of the null revision.
+var NullNode Node
+
+func (n Node) String() string {
+	return hex.EncodeToString(n[:])
+}
+
+// Revlog header values.
+const (
+	versionV0 = 0
+	versionV1 = 1
+
+	flagInlineData   = 1 << 16
+	flagGeneralDelta = 1 << 17
+)
+
+// Revision flags, stored in the index entry of each revision.
+// The text of censored, ellipsis and externally stored revisions doesn't hash to their node.
+const (
+	flagCensored       = 1 << 15
+	flagEllipsis       = 1 << 14
+	flagExtStored      = 1 << 13
+	flagHasCopiesInfo  = 1 << 12
+	knownRevisionFlags = flagCensored | flagEllipsis | flagExtStored | flagHasCopiesInfo
+	rawTextFlags       = flagCensored | flagEllipsis | flagExtStored
+)
+
+const entrySize = 64
+
+var (
+	// ErrCorrupt is returned when a revlog contains inconsistent data.
+	ErrCorrupt = errors.New("corrupt revlog")
+	// ErrNotFound is returned when a node is not stored in the revlog.
+	ErrNotFound = errors.New("no match found")
+	// ErrAmbiguous is returned when a node prefix matches several nodes.
+	ErrAmbiguous = errors.New("ambiguous identifier")
+	// ErrIntegrity is returned when the text of a revision doesn't match its node.
+	ErrIntegrity = errors.New("integrity check failed")
+)
+
+// Hash returns the node of a revision with text and parents p1 and p2:
+// the SHA-1 hash of the two parents, the smaller first, followed by the text.
+//
+// Source: mercurial/utils/storageutil.py:hashrevisionsha1()
+func Hash(text []byte, p1, p2 Node) Node {
+	a, b := p1, p2
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	h := sha1.New()
+	h.Write(a[:])
+	h.Write(b[:])
+	h.Write(text)
+
+	var node Node
+	copy(node[:], h.Sum(nil))
+	return node
+}
+
+// Entry is a single revision record of a revlog index.
+type Entry struct {
+	Offset          int64
+	Flags           uint16
+	CompressedLen   int32
+	UncompressedLen int32
+	Base            Rev
+	LinkRev         Rev
+	P1, P2          Rev
+	Node            Node
+}
+
+// Revlog is an opened revlog.
+type Revlog struct {
+	indexPath string
+	dataPath  string
+
+	inline       bool
+	generalDelta bool
+
+	index   []Entry
+	nodemap map[Node]Rev
+
+	// inlineData holds the whole index file of an inline revlog.
+	inlineData []byte
+	dataFile   *os.File
+}
+
+// Open reads the index of the revlog stored at indexPath.
+// A missing index is an empty revlog.
+func Open(indexPath string) (*Revlog, error) {
+	return OpenFiles(indexPath, strings.TrimSuffix(indexPath, ".i")+".d")
+}
+
+// OpenFiles is like Open for an index whose data file is not named after it,
+// such as the pending index of a transaction.
+func OpenFiles(indexPath, dataPath string) (*Revlog, error) {
+	r := &Revlog{
+		indexPath: indexPath,
+		dataPath:  dataPath,
+		nodemap:   make(map[Node]Rev),
+	}
+
+	data, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return r, nil
+	}
+	if len(data) < 4 {
+		return nil, r.corrupt("index is too short")
+	}
+
+	header := binary.BigEndian.Uint32(data[0:4])
+	version := header & 0xffff
+	flags := header &^ 0xffff
+	switch version {
+	case versionV1:
+		if flags&^(flagInlineData|flagGeneralDelta) != 0 {
+			return nil, fmt.Errorf("%s: unknown flags (%#04x) in version %d revlog", indexPath, flags>>16, version)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown version (%d) in revlog", indexPath, version)
+	}
+	r.inline = flags&flagInlineData != 0
+	r.generalDelta = flags&flagGeneralDelta != 0
+
+	if err := r.parseIndex(data); err != nil {
+		return nil, err
+	}
+	if r.inline {
+		r.inlineData = data
+	}
+
+	return r, nil
+}
+
+func (r *Revlog) corrupt(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %w: %s", r.indexPath, ErrCorrupt, fmt.Sprintf(format, a...))
+}
+
+func (r *Revlog) parseIndex(data []byte) error {
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < entrySize {
+			return r.corrupt("truncated index entry %d", len(r.index))
+		}
+		b := data[offset : offset+entrySize]
+
+		offsetFlags := binary.BigEndian.Uint64(b[0:8])
+		e := Entry{
+			Offset:          int64(offsetFlags >> 16),
+			Flags:           uint16(offsetFlags),
+			CompressedLen:   int32(binary.BigEndian.Uint32(b[8:12])),
+			UncompressedLen: int32(binary.BigEndian.Uint32(b[12:16])),
+			Base:            Rev(int32(binary.BigEndian.Uint32(b[16:20]))),
+			LinkRev:         Rev(int32(binary.BigEndian.Uint32(b[20:24]))),
+			P1:              Rev(int32(binary.BigEndian.Uint32(b[24:28]))),
+			P2:              Rev(int32(binary.BigEndian.Uint32(b[28:32]))),
+		}
+		copy(e.Node[:], b[32:52])
+
+		rev := Rev(len(r.index))
+		if rev == 0 {
+			// The header shares its bytes with the offset of revision 0.
+			e.Offset = 0
+		}
+		if e.CompressedLen < 0 || e.UncompressedLen < 0 || e.Base < NullRev || e.Base > rev ||
+			e.P1 < NullRev || e.P1 >= rev || e.P2 < NullRev || e.P2 >= rev {
+			return r.corrupt("invalid index entry %d", rev)
+		}
+		if e.Flags&^knownRevisionFlags != 0 {
+			return r.corrupt("incompatible revision flag '%#x' in revision %d", e.Flags&^knownRevisionFlags, rev)
+		}
+
+		r.index = append(r.index, e)
+		r.nodemap[e.Node] = rev
+
+		offset += entrySize
+		if r.inline {
+			offset += int(e.CompressedLen)
+		}
+	}
+	if offset != len(data) {
+		return r.corrupt("truncated data for revision %d", len(r.index)-1)
+	}
+	return nil
+}
+
+// Close releases the data file of the revlog.
+func (r *Revlog) Close() error {
+	if r.dataFile != nil {
+		err := r.dataFile.Close()
+		r.dataFile = nil
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of revisions.
+func (r *Revlog) Len() int {
+	return len(r.index)
+}
+
+// unknownRev returns an error unless rev is a revision of the revlog.
+func (r *Revlog) unknownRev(rev Rev) error {
+	if rev < 0 || int(rev) >= len(r.index) {
+		return fmt.Errorf("%s: unknown revision %d", r.indexPath, rev)
+	}
+	return nil
+}
+
+// Entry returns the index entry of rev.
+func (r *Revlog) Entry(rev Rev) (*Entry, error) {
+	if err := r.unknownRev(rev); err != nil {
+		return nil, err
+	}
+	return &r.index[rev], nil
+}
+
+// Node returns the hash of rev.
+func (r *Revlog) Node(rev Rev) (Node, error) {
+	if rev == NullRev {
+		return NullNode, nil
+	}
+	if err := r.unknownRev(rev); err != nil {
+		return NullNode, err
+	}
+	return r.index[rev].Node, nil
+}
+
+// node returns the hash of rev, a revision read from the index.
+func (r *Revlog) node(rev Rev) Node {
+	if rev == NullRev {
+		return NullNode
+	}
+	return r.index[rev].Node
+}
+
+// Rev returns the revision number of node.
+func (r *Revlog) Rev(node Node) (Rev, error) {
+	if node == NullNode {
+		return NullRev, nil
+	}
+	rev, ok := r.nodemap[node]
+	if !ok {
+		return NullRev, fmt.Errorf("%s: %w: %s", r.indexPath, ErrNotFound, node)
+	}
+	return rev, nil
+}
+
+// PartialMatch returns the only node whose hex form starts with prefix.
+func (r *Revlog) PartialMatch(prefix string) (Node, error) {
+	prefix = strings.ToLower(prefix)
+	var found []Node
+	if strings.HasPrefix(NullNode.String(), prefix) {
+		found = append(found, NullNode)
+	}
+	for i := range r.index {
+		if strings.HasPrefix(r.index[i].Node.String(), prefix) {
+			found = append(found, r.index[i].Node)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return NullNode, fmt.Errorf("%s: %w: %s", r.indexPath, ErrNotFound, prefix)
+	case 1:
+		return found[0], nil
+	}
+	return NullNode, fmt.Errorf("%s: %w: %s", r.indexPath, ErrAmbiguous, prefix)
+}
+
+// Parents returns the parent revisions of rev.
+func (r *Revlog) Parents(rev Rev) (Rev, Rev, error) {
+	if rev == NullRev {
+		return NullRev, NullRev, nil
+	}
+	if err := r.unknownRev(rev); err != nil {
+		return NullRev, NullRev, err
+	}
+	e := &r.index[rev]
+	return e.P1, e.P2, nil
+}
+
+// chunk returns the raw, still compressed data of rev.
+func (r *Revlog) chunk(rev Rev) ([]byte, error) {
+	e := &r.index[rev]
+	length := int64(e.CompressedLen)
+
+	if r.inline {
+		start := e.Offset + int64(rev+1)*entrySize
+		if start+length > int64(len(r.inlineData)) {
+			return nil, r.corrupt("truncated data for revision %d", rev)
+		}
+		return r.inlineData[start : start+length], nil
+	}
+
+	if r.dataFile == nil {
+		f, err := os.Open(r.dataPath)
+		if err != nil {
+			return nil, err
+		}
+		r.dataFile = f
+	}
+	buf := make([]byte, length)
+	if _, err := r.dataFile.ReadAt(buf, e.Offset); err != nil {
+		return nil, r.corrupt("reading data for revision %d: %s", rev, err)
+	}
+	return buf, nil
+}
+
+// decompress decodes a revlog chunk according to its first byte.
+func (r *Revlog) decompress(rev Rev, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	switch data[0] {
+	case 0:
+		// Stored as is: the text already starts with a null byte.
+		return data, nil
+	case 'u':
+		return data[1:], nil
+	case 'x':
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, r.corrupt("revision %d: %s", rev, err)
+		}
+		defer zr.Close()
+		out, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, r.corrupt("revision %d: %s", rev, err)
+		}
+		return out, nil
+	}
+	return nil, r.corrupt("revision %d: unknown compression type %q", rev, data[0:1])
+}
+
+// deltaChain returns the revisions whose chunks rebuild rev, starting with a full text.
+func (r *Revlog) deltaChain(rev Rev) []Rev {
+	var chain []Rev
+	for {
+		base := r.index[rev].Base
+		chain = append(chain, rev)
+		if base == rev || base == NullRev {
+			break
+		}
+		if r.generalDelta {
+			rev = base
+		} else {
+			rev--
+		}
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// Revision returns the full text of rev.
+func (r *Revlog) Revision(rev Rev) ([]byte, error) {
+	if rev == NullRev {
+		return nil, nil
+	}
+	if err := r.unknownRev(rev); err != nil {
+		return nil, err
+	}
+
+	var text []byte
+	var deltas [][]byte
+	chain := r.deltaChain(rev)
+	for i, crev := range chain {
+		raw, err := r.chunk(crev)
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.decompress(crev, raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			text = data
+		} else {
+			deltas = append(deltas, data)
+		}
+	}
+	text, err := mpatch.Patches(text, deltas)
+	if err != nil {
+		return nil, r.corrupt("revision %d: %s", rev, err)
+	}
+
+	e := &r.index[rev]
+	if int(e.UncompressedLen) != len(text) {
+		return nil, r.corrupt("revision %d: size mismatch", rev)
+	}
+	if e.Flags&rawTextFlags == 0 && Hash(text, r.node(e.P1), r.node(e.P2)) != e.Node {
+		return nil, fmt.Errorf("%w on %s:%d", ErrIntegrity, r.indexPath, rev)
+	}
+	return text, nil
+}
+
+// RevisionByNode returns the full text of node.
+func (r *Revlog) RevisionByNode(node Node) ([]byte, error) {
+	rev, err := r.Rev(node)
+	if err != nil {
+		return nil, err
+	}
+	return r.Revision(rev)
+}
@@ -0,0 +1,207 @@
+package revlog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func delta(hunks ...interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(hunks); i += 3 {
+		data := hunks[i+2].(string)
+		binary.Write(&buf, binary.BigEndian, uint32(hunks[i].(int)))
+		binary.Write(&buf, binary.BigEndian, uint32(hunks[i+1].(int)))
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(data)
+	}
+	return buf.Bytes()
+}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// writeRevlog writes a linear revlog whose chunks are given, and returns its index path.
+// Revision i has the parent i-1.
+func writeRevlog(t *testing.T, inline, generalDelta bool, texts []string, chunks [][]byte, bases []Rev) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "revlog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(dir, "test.i")
+
+	var index, data bytes.Buffer
+	var offset int64
+	p1 := NullNode
+	for i, chunk := range chunks {
+		node := Hash([]byte(texts[i]), p1, NullNode)
+		entry := make([]byte, entrySize)
+		binary.BigEndian.PutUint64(entry[0:8], uint64(offset)<<16)
+		binary.BigEndian.PutUint32(entry[8:12], uint32(len(chunk)))
+		binary.BigEndian.PutUint32(entry[12:16], uint32(len(texts[i])))
+		binary.BigEndian.PutUint32(entry[16:20], uint32(bases[i]))
+		binary.BigEndian.PutUint32(entry[20:24], uint32(i))
+		binary.BigEndian.PutUint32(entry[24:28], uint32(i-1))
+		binary.BigEndian.PutUint32(entry[28:32], 0xffffffff)
+		copy(entry[32:52], node[:])
+		if i == 0 {
+			header := uint32(versionV1)
+			if inline {
+				header |= flagInlineData
+			}
+			if generalDelta {
+				header |= flagGeneralDelta
+			}
+			binary.BigEndian.PutUint32(entry[0:4], header)
+		}
+
+		index.Write(entry)
+		if inline {
+			index.Write(chunk)
+		} else {
+			data.Write(chunk)
+		}
+		offset += int64(len(chunk))
+		p1 = node
+	}
+
+	if err := ioutil.WriteFile(indexPath, index.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !inline {
+		if err := ioutil.WriteFile(filepath.Join(dir, "test.d"), data.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return indexPath
+}
+
+var revlogTexts = []string{
+	"line 1\nline 2\n",
+	"LINE 1\nline 2\n",
+	"line 1\nline 2\nline 3\n",
+	"line 1\nline 2\nline 3\nline 4\n",
+}
+
+func TestRevision(t *testing.T) {
+	tests := []struct {
+		name                 string
+		inline, generalDelta bool
+		chunks               [][]byte
+		bases                []Rev
+	}{
+		{
+			name:   "inline",
+			inline: true,
+			chunks: [][]byte{
+				append([]byte("u"), revlogTexts[0]...),
+				compress(delta(0, 7, "LINE 1\n")),
+				compress([]byte(revlogTexts[2])),
+				append([]byte("u"), delta(21, 21, "line 4\n")...),
+			},
+			// Without general delta, the base is where the chain starts.
+			bases: []Rev{0, 0, 2, 2},
+		},
+		{
+			name:         "separate data, general delta",
+			generalDelta: true,
+			chunks: [][]byte{
+				compress([]byte(revlogTexts[0])),
+				delta(0, 7, "LINE 1\n"),
+				compress(delta(14, 14, "line 3\n")),
+				compress(delta(14, 14, "line 3\nline 4\n")),
+			},
+			// With general delta, the base is the revision the delta applies to.
+			bases: []Rev{0, 0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		indexPath := writeRevlog(t, tt.inline, tt.generalDelta, revlogTexts, tt.chunks, tt.bases)
+		defer os.RemoveAll(filepath.Dir(indexPath))
+
+		r, err := Open(indexPath)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.Len() != len(revlogTexts) {
+			t.Errorf("%s: Len() = %d, want %d", tt.name, r.Len(), len(revlogTexts))
+		}
+		for rev, want := range revlogTexts {
+			text, err := r.Revision(Rev(rev))
+			if err != nil || string(text) != want {
+				t.Errorf("%s: Revision(%d) = %q, %v, want %q", tt.name, rev, text, err, want)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestRevisionIntegrity(t *testing.T) {
+	texts := []string{"good text\n"}
+	indexPath := writeRevlog(t, true, false, texts, [][]byte{[]byte("uevil text\n")}, []Rev{0})
+	defer os.RemoveAll(filepath.Dir(indexPath))
+
+	r, err := Open(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Revision(0); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Revision(0) of a corrupted revision: error = %v, want %v", err, ErrIntegrity)
+	}
+}
+
+func TestUnknownRevision(t *testing.T) {
+	indexPath := writeRevlog(t, true, false, revlogTexts[:1], [][]byte{append([]byte("u"), revlogTexts[0]...)}, []Rev{0})
+	defer os.RemoveAll(filepath.Dir(indexPath))
+
+	r, err := Open(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range []Rev{-2, 1, 100} {
+		if _, err := r.Entry(rev); err == nil {
+			t.Errorf("Entry(%d) succeeded", rev)
+		}
+		if _, err := r.Node(rev); err == nil {
+			t.Errorf("Node(%d) succeeded", rev)
+		}
+		if _, _, err := r.Parents(rev); err == nil {
+			t.Errorf("Parents(%d) succeeded", rev)
+		}
+		if _, err := r.Revision(rev); err == nil {
+			t.Errorf("Revision(%d) succeeded", rev)
+		}
+	}
+
+	if node, err := r.Node(NullRev); err != nil || node != NullNode {
+		t.Errorf("Node(NullRev) = %s, %v, want the null node", node, err)
+	}
+	if p1, p2, err := r.Parents(0); err != nil || p1 != NullRev || p2 != NullRev {
+		t.Errorf("Parents(0) = %d, %d, %v, want null parents", p1, p2, err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	// The node of an empty file without parents, well known to Mercurial users.
+	if got, want := Hash(nil, NullNode, NullNode).String(), "b80de5d138758541c5f05265ad144ab9fa86d1db"; got != want {
+		t.Errorf("Hash(empty) = %s, want %s", got, want)
+	}
+
+	p1 := Node{1}
+	p2 := Node{2}
+	if Hash([]byte("text"), p1, p2) != Hash([]byte("text"), p2, p1) {
+		t.Error("Hash depends on the order of the parents")
+	}
+}
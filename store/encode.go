@@ -0,0 +1,200 @@
+// Package store maps tracked file paths to revlog paths inside .hg/store.
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Store layouts depend on repository requirements:
+//
+//   - no "store": revlogs live in .hg/data, paths only go through encodedir;
+//   - "store": revlogs live in .hg/store, paths are encoded with encodefilename;
+//   - "store" and "fncache": paths use the hybrid encoding, which additionally escapes
+//     Windows reserved names and hashes paths longer than 120 characters.
+//     "dotencode" also escapes leading periods and spaces.
+//
+// Source: mercurial/store.py
+
+const (
+	maxStorePathLen = 120
+	dirPrefixLen    = 8
+	maxShortDirsLen = 8*(dirPrefixLen+1) - 4
+)
+
+// EncodeDir escapes directories that would clash with revlog file names.
+//
+//	foo.i/bar.d -> foo.i.hg/bar.d
+func EncodeDir(path string) string {
+	if !strings.Contains(path, ".hg/") && !strings.Contains(path, ".i/") && !strings.Contains(path, ".d/") {
+		return path
+	}
+	path = strings.Replace(path, ".hg/", ".hg.hg/", -1)
+	path = strings.Replace(path, ".i/", ".i.hg/", -1)
+	path = strings.Replace(path, ".d/", ".d.hg/", -1)
+	return path
+}
+
+// reserved reports whether c is not safe for a file name on all platforms.
+func reserved(c byte) bool {
+	return c < 32 || c >= 126 || strings.IndexByte("\\:*?\"<>|", c) >= 0
+}
+
+// encodeFname escapes reserved characters and uppercase letters ("FOO_bar" -> "_f_o_o__bar").
+func encodeFname(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case reserved(c):
+			fmt.Fprintf(&b, "~%02x", c)
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte('_')
+			b.WriteByte(c + 'a' - 'A')
+		case c == '_':
+			b.WriteString("__")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// lowerEncode escapes reserved characters and lowercases uppercase letters.
+// It is only used for hashed paths, where the hash keeps names unique.
+func lowerEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case reserved(c):
+			fmt.Fprintf(&b, "~%02x", c)
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte(c + 'a' - 'A')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EncodeFilename is the encoding of the "store" layout.
+func EncodeFilename(path string) string {
+	return encodeFname(EncodeDir(path))
+}
+
+var (
+	winReserved3 = []string{"aux", "con", "prn", "nul"}
+	winReserved4 = []string{"com", "lpt"}
+)
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// auxEncode escapes Windows reserved names and trailing periods or spaces
+// in every path component ("aux.c" -> "au~78.c", "foo." -> "foo~2e").
+// With dotencode leading periods and spaces are escaped as well.
+func auxEncode(parts []string, dotencode bool) []string {
+	for i, n := range parts {
+		if n == "" {
+			continue
+		}
+		if dotencode && (n[0] == '.' || n[0] == ' ') {
+			n = fmt.Sprintf("~%02x", n[0]) + n[1:]
+			parts[i] = n
+		} else {
+			l := strings.IndexByte(n, '.')
+			if l < 0 {
+				l = len(n)
+			}
+			if (l == 3 && contains(winReserved3, n[:3])) ||
+				(l == 4 && n[3] >= '1' && n[3] <= '9' && contains(winReserved4, n[:3])) {
+				n = n[:2] + fmt.Sprintf("~%02x", n[2]) + n[3:]
+				parts[i] = n
+			}
+		}
+		if last := n[len(n)-1]; last == '.' || last == ' ' {
+			parts[i] = n[:len(n)-1] + fmt.Sprintf("~%02x", last)
+		}
+	}
+	return parts
+}
+
+// splitExt mirrors Python's os.path.splitext: leading periods do not start an extension.
+func splitExt(name string) string {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || strings.Trim(name[:i], ".") == "" {
+		return ""
+	}
+	return name[i:]
+}
+
+// hashEncode builds the "dh/" name of a path too long for the hybrid encoding.
+// path is already dir-encoded and starts with "data/" or "meta/".
+func hashEncode(p string, dotencode bool) string {
+	sum := sha1.Sum([]byte(p))
+	digest := hex.EncodeToString(sum[:])
+
+	parts := auxEncode(strings.Split(lowerEncode(p[5:]), "/"), dotencode)
+	basename := parts[len(parts)-1]
+	ext := splitExt(basename)
+
+	var sdirs []string
+	sdirslen := 0
+	for _, part := range parts[:len(parts)-1] {
+		d := part
+		if len(d) > dirPrefixLen {
+			d = d[:dirPrefixLen]
+		}
+		if d == "" {
+			continue
+		}
+		if last := d[len(d)-1]; last == '.' || last == ' ' {
+			// Windows can't access dirs ending in period or space.
+			d = d[:len(d)-1] + "_"
+		}
+		var t int
+		if sdirslen == 0 {
+			t = len(d)
+		} else {
+			t = sdirslen + 1 + len(d)
+			if t > maxShortDirsLen {
+				break
+			}
+		}
+		sdirs = append(sdirs, d)
+		sdirslen = t
+	}
+	dirs := strings.Join(sdirs, "/")
+	if dirs != "" {
+		dirs += "/"
+	}
+
+	res := "dh/" + dirs + digest + ext
+	if spaceleft := maxStorePathLen - len(res); spaceleft > 0 {
+		filler := basename
+		if len(filler) > spaceleft {
+			filler = filler[:spaceleft]
+		}
+		res = "dh/" + dirs + filler + digest + ext
+	}
+	return res
+}
+
+// HybridEncode is the encoding of the "fncache" layout.
+func HybridEncode(p string, dotencode bool) string {
+	p = EncodeDir(p)
+	res := strings.Join(auxEncode(strings.Split(encodeFname(p), "/"), dotencode), "/")
+	if len(res) > maxStorePathLen {
+		res = hashEncode(p, dotencode)
+	}
+	return res
+}
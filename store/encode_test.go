@@ -0,0 +1,94 @@
+package store
+
+import "testing"
+
+func TestEncodeDir(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"data/foo.i", "data/foo.i"},
+		{"data/foo.i/bla.i", "data/foo.i.hg/bla.i"},
+		{"data/foo.i.hg/bla.i", "data/foo.i.hg.hg/bla.i"},
+		{"data/foo.d/.hg/x.i", "data/foo.d.hg/.hg.hg/x.i"},
+	}
+	for _, tt := range tests {
+		got := EncodeDir(tt.path)
+		if got != tt.want {
+			t.Errorf("EncodeDir(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if back := DecodeDir(got); back != tt.path {
+			t.Errorf("DecodeDir(%q) = %q, want %q", got, back, tt.path)
+		}
+	}
+}
+
+func TestEncodeFilename(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"foo.i/bar.d/bla.hg/hi:world?/HELLO", "foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o"},
+		{"the\x07quick\xadshot", "the~07quick~adshot"},
+		{"under_score~", "under__score~7e"},
+		{"aux.c", "aux.c"},
+	}
+	for _, tt := range tests {
+		if got := EncodeFilename(tt.path); got != tt.want {
+			t.Errorf("EncodeFilename(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// Expected values computed with mercurial/store.py:_hybridencode.
+var hybridTests = []struct{ path, want, wantDotencode string }{
+	{"data/src/Foo/aux.c.i",
+		"data/src/_foo/au~78.c.i",
+		"data/src/_foo/au~78.c.i"},
+	{"data/.hgignore.i",
+		"data/.hgignore.i",
+		"data/~2ehgignore.i"},
+	{"data/ .foo/bar .i",
+		"data/ .foo/bar .i",
+		"data/~20.foo/bar .i"},
+	{"data/foo.i/bar.d/bla.hg/hi:world?/HELLO.i",
+		"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o.i",
+		"data/foo.i.hg/bar.d.hg/bla.hg.hg/hi~3aworld~3f/_h_e_l_l_o.i"},
+	{"data/aux.bla/bla.aux/prn/PRN/lpt/com3/nul/coma/foo.NUL/normal.c.i",
+		"data/au~78.bla/bla.aux/pr~6e/_p_r_n/lpt/co~6d3/nu~6c/coma/foo._n_u_l/normal.c.i",
+		"data/au~78.bla/bla.aux/pr~6e/_p_r_n/lpt/co~6d3/nu~6c/coma/foo._n_u_l/normal.c.i"},
+	{"data/com1com2/lpt9.lpt4.lpt1/conprn/com0/lpt0/foo..i",
+		"data/com1com2/lp~749.lpt4.lpt1/conprn/com0/lpt0/foo..i",
+		"data/com1com2/lp~749.lpt4.lpt1/conprn/com0/lpt0/foo..i"},
+	{"data/abcdefghijklmnopqrstuvwxyz0123456789 !#%&'()+,-.;=[]^`{}.i",
+		"data/abcdefghijklmnopqrstuvwxyz0123456789 !#%&'()+,-.;=[]^`{}.i",
+		"data/abcdefghijklmnopqrstuvwxyz0123456789 !#%&'()+,-.;=[]^`{}.i"},
+	{"data/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.i",
+		"dh/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaae734fc3e857dc9e05046cc41c3197601f8eda6ec.i",
+		"dh/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaae734fc3e857dc9e05046cc41c3197601f8eda6ec.i"},
+	{"data/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.i",
+		"dh/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa76f9bf7346aca0b9615a7170332a5829f45b7910.i",
+		"dh/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa76f9bf7346aca0b9615a7170332a5829f45b7910.i"},
+	{"data/Project Files/.Settings/Very Long Directory Name/another.dir./AUX/xyzzy.and.more/deeper/still/Some Really Long File Name.txt.i",
+		"dh/project_/.setting/very lon/another_/au~78/xyzzy.an/deeper/still/some really64f3030fced2b612f89971767dc50398db68f767.i",
+		"dh/project_/~2esetti/very lon/another_/au~78/xyzzy.an/deeper/still/some really64f3030fced2b612f89971767dc50398db68f767.i"},
+	{"data/a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u/v/w/x/y/z/0/1/2/3/4/5/6/7/8/9/a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u/v/w/x/y/z.d",
+		"dh/a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u/v/w/x/y/z/0/1/2/3/4/5/6/7/z.dd9aa4b28ecc638a89f3e5bd12557b298d8bf4e15.d",
+		"dh/a/b/c/d/e/f/g/h/i/j/k/l/m/n/o/p/q/r/s/t/u/v/w/x/y/z/0/1/2/3/4/5/6/7/z.dd9aa4b28ecc638a89f3e5bd12557b298d8bf4e15.d"},
+	{"meta/Dir With Spaces/0123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789/00manifest.i",
+		"dh/dir with/01234567/00manifest.i23a6174ff31f97471e85c15a1b375d14023b58a7.i",
+		"dh/dir with/01234567/00manifest.i23a6174ff31f97471e85c15a1b375d14023b58a7.i"},
+}
+
+func TestHybridEncode(t *testing.T) {
+	for _, tt := range hybridTests {
+		if got := HybridEncode(tt.path, false); got != tt.want {
+			t.Errorf("HybridEncode(%q, false) = %q, want %q", tt.path, got, tt.want)
+		}
+		if got := HybridEncode(tt.path, true); got != tt.wantDotencode {
+			t.Errorf("HybridEncode(%q, true) = %q, want %q", tt.path, got, tt.wantDotencode)
+		}
+	}
+}
+
+func TestHybridEncodeLength(t *testing.T) {
+	for _, tt := range hybridTests {
+		if got := HybridEncode(tt.path, true); len(got) > maxStorePathLen {
+			t.Errorf("HybridEncode(%q) is %d bytes long", tt.path, len(got))
+		}
+	}
+}
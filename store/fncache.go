@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The fncache of a store using the fncache layout lists the unencoded paths of its
+// revlogs ("data/foo.i", "meta/dir/00manifest.i"), one per line. Since the hybrid
+// encoding cannot be reversed, it is the only way to enumerate the tracked histories.
+// Paths are written dir-encoded and every line ends with a newline.
+//
+// Source: mercurial/store.py:fncache
+
+// FncacheError is returned for a fncache Mercurial refuses to load.
+// "hg debugrebuildfncache" rebuilds it.
+type FncacheError struct {
+	// Line is the number of an empty line, 0 if the last line has no newline.
+	Line int
+}
+
+func (e *FncacheError) Error() string {
+	if e.Line == 0 {
+		return "fncache does not ends with a newline"
+	}
+	return fmt.Sprintf("invalid entry in fncache, line %d", e.Line)
+}
+
+// DecodeDir reverses EncodeDir.
+//
+//	foo.i.hg/bar.d -> foo.i/bar.d
+func DecodeDir(path string) string {
+	if !strings.Contains(path, ".hg/") {
+		return path
+	}
+	path = strings.Replace(path, ".d.hg/", ".d/", -1)
+	path = strings.Replace(path, ".i.hg/", ".i/", -1)
+	path = strings.Replace(path, ".hg.hg/", ".hg/", -1)
+	return path
+}
+
+// ParseFncache parses the content of a fncache into the set of its paths.
+func ParseFncache(data []byte) (map[string]bool, error) {
+	entries := make(map[string]bool)
+	if len(data) == 0 {
+		return entries, nil
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return nil, &FncacheError{}
+	}
+
+	lines := strings.Split(string(data[:len(data)-1]), "\n")
+	for i, line := range lines {
+		if line == "" {
+			return nil, &FncacheError{Line: i + 1}
+		}
+		entries[DecodeDir(line)] = true
+	}
+	return entries, nil
+}
+
+// ReadFncache reads the fncache file at path. A missing file is an empty fncache.
+func ReadFncache(path string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]bool), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseFncache(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// Fncache returns the paths listed in the fncache of the store,
+// or nil if the store does not use the fncache layout.
+func (s *Store) Fncache() (map[string]bool, error) {
+	if !s.fncache {
+		return nil, nil
+	}
+	return ReadFncache(filepath.Join(s.Dir, "fncache"))
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFncache(t *testing.T) {
+	entries, err := ParseFncache([]byte("data/a.i\ndata/foo.i.hg/bar.i\nmeta/dir/00manifest.i\ndata/a.i\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"data/a.i": true, "data/foo.i/bar.i": true, "meta/dir/00manifest.i": true}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+
+	entries, err = ParseFncache(nil)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("empty fncache = %v, %v", entries, err)
+	}
+}
+
+func TestParseFncacheInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		line int
+		msg  string
+	}{
+		{"data/a.i\ndata/b.i", 0, "fncache does not ends with a newline"},
+		{"data/a.i\n\ndata/b.i\n", 2, "invalid entry in fncache, line 2"},
+		{"\n", 1, "invalid entry in fncache, line 1"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFncache([]byte(tt.data))
+		var fe *FncacheError
+		if !errors.As(err, &fe) {
+			t.Errorf("ParseFncache(%q) error = %v, want a FncacheError", tt.data, err)
+			continue
+		}
+		if fe.Line != tt.line || fe.Error() != tt.msg {
+			t.Errorf("ParseFncache(%q) error = %v at line %d, want %q at line %d", tt.data, fe, fe.Line, tt.msg, tt.line)
+		}
+	}
+}
+
+func TestStoreFncache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(dir, map[string]bool{StoreRequirement: true, FncacheRequirement: true, DotencodeRequirement: true})
+	if got, want := s.FilelogPath("src/Foo/.aux.c"), filepath.Join(dir, "store", "data", "src", "_foo", "~2eaux.c.i"); got != want {
+		t.Errorf("FilelogPath = %q, want %q", got, want)
+	}
+
+	// A missing fncache is empty.
+	entries, err := s.Fncache()
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("missing fncache = %v, %v", entries, err)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.Dir, "fncache"), []byte("data/src/Foo/.aux.c.i\ndata/src/Foo/.aux.c.d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Fncache(); !errors.As(err, new(*FncacheError)) {
+		t.Errorf("truncated fncache error = %v", err)
+	}
+
+	plain := New(dir, map[string]bool{StoreRequirement: true})
+	if got, want := plain.FilelogPath("src/Foo/aux.c"), filepath.Join(dir, "store", "data", "src", "_foo", "aux.c.i"); got != want {
+		t.Errorf("FilelogPath = %q, want %q", got, want)
+	}
+	if entries, err := plain.Fncache(); entries != nil || err != nil {
+		t.Errorf("fncache without the fncache layout = %v, %v", entries, err)
+	}
+}
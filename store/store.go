@@ -0,0 +1,48 @@
+package store
+
+import (
+	"path/filepath"
+)
+
+// Requirement names that select the store layout.
+const (
+	StoreRequirement     = "store"
+	FncacheRequirement   = "fncache"
+	DotencodeRequirement = "dotencode"
+)
+
+// Store locates revlogs inside a repository.
+type Store struct {
+	// Dir is the directory revlogs are stored in: .hg/store or, for old repositories, .hg.
+	Dir string
+
+	encode  func(string) string
+	fncache bool
+}
+
+// New returns the store of the repository whose .hg directory is hgDir.
+func New(hgDir string, requirements map[string]bool) *Store {
+	if !requirements[StoreRequirement] {
+		return &Store{Dir: hgDir, encode: EncodeDir}
+	}
+
+	s := &Store{Dir: filepath.Join(hgDir, "store")}
+	if requirements[FncacheRequirement] {
+		dotencode := requirements[DotencodeRequirement]
+		s.encode = func(p string) string { return HybridEncode(p, dotencode) }
+		s.fncache = true
+	} else {
+		s.encode = EncodeFilename
+	}
+	return s
+}
+
+// Join returns the filesystem path of a store file such as "00changelog.i" or "data/foo.i".
+func (s *Store) Join(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(s.encode(name)))
+}
+
+// FilelogPath returns the filesystem path of the filelog index for a tracked file.
+func (s *Store) FilelogPath(path string) string {
+	return s.Join("data/" + path + ".i")
+}